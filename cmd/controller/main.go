@@ -0,0 +1,119 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	"github.com/golang/glog"
+	securityv1 "github.com/openshift/api/security/v1"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/controller"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/manifests"
+)
+
+const (
+	sourcePerformanceProfile = "performanceprofile"
+	sourceKubelet            = "kubelet"
+)
+
+type Args struct {
+	Namespace      string
+	Source         string
+	PoolLabel      string
+	ServiceMonitor bool
+}
+
+func main() {
+	args := parseArgs()
+	if args.Namespace == "" {
+		glog.Fatalf("--namespace is required")
+	}
+
+	cfg, err := config.GetConfig()
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	if err := securityv1.AddToScheme(scheme.Scheme); err != nil {
+		glog.Fatalf("%v", err)
+	}
+	cli, err := client.New(cfg, client.Options{})
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+
+	renderer := controller.Renderer{Namespace: args.Namespace}
+	if args.ServiceMonitor {
+		renderer.Options = append(renderer.Options, manifests.WithServiceMonitor())
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var c *controller.Controller
+	switch args.Source {
+	case sourcePerformanceProfile:
+		dyn, err := dynamic.NewForConfig(cfg)
+		if err != nil {
+			glog.Fatalf("%v", err)
+		}
+		r := &controller.PerformanceProfileReconciler{Client: cli, Renderer: renderer}
+		c = controller.NewPerformanceProfileController(ctx, r, cs, dyn)
+	case sourceKubelet:
+		if args.PoolLabel == "" {
+			glog.Fatalf("--pool-label is required with --source=%s", sourceKubelet)
+		}
+		r := &controller.KubeletReconciler{
+			Client:       cli,
+			Renderer:     renderer,
+			PoolLabel:    args.PoolLabel,
+			ReservedCPUs: controller.KubeletReservedCPUs(cs),
+		}
+		c = controller.NewKubeletController(ctx, r, cs)
+	default:
+		glog.Fatalf("unsupported source %q, expected %q or %q", args.Source, sourcePerformanceProfile, sourceKubelet)
+	}
+
+	glog.Infof("deploying the plugin to namespace %q from %s", args.Namespace, args.Source)
+	if err := c.Run(ctx); err != nil {
+		glog.Fatalf("controller exited with error %v", err)
+	}
+}
+
+func parseArgs() *Args {
+	args := &Args{}
+	flag.StringVar(&args.Namespace, "namespace", os.Getenv("POD_NAMESPACE"), "namespace to deploy the plugin in")
+	flag.StringVar(&args.Source, "source", sourcePerformanceProfile, "source of the node pools and their shared cpus (performanceprofile or kubelet)")
+	flag.StringVar(&args.PoolLabel, "pool-label", "", "node label whose values are the node pools, with --source=kubelet")
+	flag.BoolVar(&args.ServiceMonitor, "service-monitor", false, "deploy a Service and a ServiceMonitor for each pool's plugin")
+	flag.Parse()
+	return args
+}
@@ -19,41 +19,271 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"os"
+	"strings"
 
 	"github.com/golang/glog"
 	"github.com/kubevirt/device-plugin-manager/pkg/dpm"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
 
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/config"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/cpumanager"
 	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/deviceplugin"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/events"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/health"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/metrics"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/mutualcpus"
 	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/nriplugin"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/podresources"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/status"
 )
 
+type Args struct {
+	nriplugin.Args
+	ConfigFile         string
+	MutualCPUsFile     string
+	DevicesHeadroom    int
+	OptInPolicy        string
+	CPUTuningPolicy    string
+	CFSQuotaPolicy     string
+	SharedMilliCPUs    int64
+	PodResourcesSocket string
+	MetricsBindAddress string
+	HealthBindAddress  string
+	NodeStatus         string
+	CPUManagerState    string
+	Events             bool
+}
+
 func main() {
 	args := parseArgs()
-	p, err := nriplugin.New(args)
+	cfg, err := loadConfig(args)
 	if err != nil {
 		glog.Fatalf("%v", err)
 	}
+	deviceplugin.SetResourceNames(cfg.Resources.Namespace, cfg.Resources.Name, cfg.Resources.EnvVarName)
+
+	var w *mutualcpus.Watcher
+	if args.MutualCPUsFile != "" {
+		if args.MutualCPUs != "" {
+			glog.Fatalf("--mutual-cpus and --mutual-cpus-file are mutually exclusive")
+		}
+		if w, err = mutualcpus.NewWatcher(args.MutualCPUsFile); err != nil {
+			glog.Fatalf("%v", err)
+		}
+		args.MutualCPUs = w.CPUs().String()
+		cfg.MutualCPUs = args.MutualCPUs
+		if err := cfg.Validate(); err != nil {
+			glog.Fatalf("invalid configuration: %v", err)
+		}
+	}
 
-	dp, err := deviceplugin.New(args.MutualCPUs)
+	// not ready until the runtime configures the plugin
+	health.Register(health.NRI)
+	p, err := nriplugin.New(&args.Args)
 	if err != nil {
 		glog.Fatalf("%v", err)
 	}
 
+	p.Config = cfg
+	dp, mc, err := deviceplugin.New(deviceplugin.Options{
+		MutualCPUs: args.MutualCPUs,
+		Pools:      args.SharedPools,
+		Topology:   p.Topology,
+		Headroom:   cfg.Devices.Headroom,
+		Limit:      cfg.Devices.Limit,
+	})
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	p.Devices = mc
+	p.AddMutualCPUsHandler(mc.SetCPUs)
+
+	if args.PodResourcesSocket != "" {
+		prc, err := podresources.New(args.PodResourcesSocket)
+		if err != nil {
+			glog.Fatalf("%v", err)
+		}
+		defer prc.Close()
+		p.PodResources = prc
+		mc.SetAllocationLister(prc)
+	}
+
+	if args.MetricsBindAddress != "" {
+		go func() {
+			if err := metrics.Serve(args.MetricsBindAddress); err != nil {
+				glog.Fatalf("metrics server exited with error %v", err)
+			}
+		}()
+	}
+
+	if args.HealthBindAddress != "" {
+		go func() {
+			if err := health.Serve(args.HealthBindAddress); err != nil {
+				glog.Fatalf("health probes server exited with error %v", err)
+			}
+		}()
+	}
+
+	var v *cpumanager.Validator
+	if args.CPUManagerState != "" {
+		cpumanager.StatePath = args.CPUManagerState
+		var recorder events.Recorder
+		if args.Events {
+			if recorder, err = newEventRecorder(); err != nil {
+				glog.Warningf("events are disabled: %v", err)
+			}
+		}
+		v = cpumanager.NewValidator(p.SharedCPUs, recorder)
+		// refuse to start with shared cpus that guaranteed containers use exclusively
+		if err := v.Validate(); err != nil {
+			glog.Fatalf("%v", err)
+		}
+		p.AddMutualCPUsHandler(v.OnSharedCPUsChange)
+		go func() {
+			if err := v.Run(context.Background()); err != nil {
+				glog.Fatalf("shared cpus validator exited with error %v", err)
+			}
+		}()
+	}
+
+	if args.NodeStatus != "" {
+		r, err := newStatusReporter(args.NodeStatus, p)
+		if err != nil {
+			glog.Fatalf("%v", err)
+		}
+		go func() {
+			if err := r.Run(context.Background()); err != nil {
+				glog.Fatalf("node status reporter exited with error %v", err)
+			}
+		}()
+	}
+
+	if w != nil {
+		w.AddHandler(mc.SetCPUs)
+		w.AddHandler(p.UpdateMutualCPUs)
+		if v != nil {
+			w.AddHandler(v.OnSharedCPUsChange)
+		}
+		go func() {
+			if err := w.Run(context.Background()); err != nil {
+				glog.Fatalf("mutual cpus watcher exited with error %v", err)
+			}
+		}()
+	}
+
 	execute(p, dp)
 }
 
-func parseArgs() *nriplugin.Args {
-	args := &nriplugin.Args{}
+func parseArgs() *Args {
+	args := &Args{}
 	flag.StringVar(&args.PluginName, "name", "", "plugin name to register to NRI")
 	flag.StringVar(&args.PluginIdx, "idx", "", "plugin index to register to NRI")
+	flag.StringVar(&args.ConfigFile, "config", "", "plugin configuration file; flags that are set explicitly override it")
 	flag.StringVar(&args.MutualCPUs, "mutual-cpus", "", "mutual cpus list")
+	flag.StringVar(&args.Runtime, "runtime", "", "container runtime (crio or containerd); detected from NRI when empty")
+	flag.StringVar(&args.MutualCPUsFile, "mutual-cpus-file", "", "file holding the mutual cpus list, watched for changes")
+	flag.BoolVar(&args.NUMAAware, "numa-aware", false, "add only the shared cpus that are on the NUMA nodes of the container's exclusive cpus")
+	flag.IntVar(&args.DevicesHeadroom, "devices-headroom", 15, "number of free devices to advertise on top of the allocated ones")
+	flag.StringVar(&args.PodResourcesSocket, "pod-resources-socket", podresources.SocketPath, "kubelet PodResources API socket for accurate device accounting; disabled when empty")
+	flag.StringVar(&args.MetricsBindAddress, "metrics-bind-address", ":9400", "address to serve the prometheus metrics on; disabled when empty")
+	flag.StringVar(&args.HealthBindAddress, "health-probe-bind-address", ":8081", "address to serve the liveness (/healthz) and readiness (/readyz) probes on; disabled when empty")
+	flag.StringVar(&args.NodeStatus, "node-status", "", "report the node's shared cpus status as a MixedCPUNodeStatus object (cr) or as node annotations (annotations); disabled when empty")
+	flag.StringVar(&args.CPUManagerState, "cpu-manager-state", cpumanager.StatePath, "kubelet CPU Manager state file, for validating that the shared cpus are not allocated exclusively; disabled when empty")
+	flag.BoolVar(&args.Events, "events", true, "emit Kubernetes events about the node, e.g. when the shared cpus conflict with the CPU Manager")
+	flag.StringVar(&args.OptInPolicy, "opt-in-policy", config.OptInDevice, "how containers request the shared cpus: by their device resource (device), by the pod's annotations (annotation) or by either of them (both)")
+	flag.StringVar(&args.CPUTuningPolicy, "cpu-tuning-policy", config.CPUTuningExclusive, "containers with shared cpus in pods that tune their cpus with CRI-O annotations get the shared cpus once the runtime tuned their exclusive cpus (exclusive), or are refused (reject)")
+	flag.StringVar(&args.CFSQuotaPolicy, "cfs-quota-policy", config.QuotaPolicyProportional, "cfs quota of containers with shared cpus: proportional, unlimited, fixed or burst; pods can override it with the "+nriplugin.CFSQuotaPolicyAnnotation+" annotation")
+	flag.Int64Var(&args.SharedMilliCPUs, "shared-milli-cpus", 0, "budget in millicores that containers get for the shared cpus under the fixed cfs quota policy")
+	flag.Var((*stringSlice)(&args.SharedPools), "shared-pool", "named shared pool in the format of <name>:<cpus>, can be repeated")
 	flag.Parse()
 	return args
 }
 
+// loadConfig reads the configuration file, if any, overrides it with
+// the flags that were set explicitly and sets args from the result.
+func loadConfig(args *Args) (*config.Config, error) {
+	cfg := config.Default()
+	if args.ConfigFile != "" {
+		var err error
+		if cfg, err = config.Load(args.ConfigFile); err != nil {
+			return nil, err
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "mutual-cpus":
+			cfg.MutualCPUs = args.MutualCPUs
+		case "shared-pool":
+			cfg.SharedPools = nil
+			for _, pool := range args.SharedPools {
+				name, cpus, _ := strings.Cut(pool, ":")
+				cfg.SharedPools = append(cfg.SharedPools, config.SharedPool{Name: name, CPUs: cpus})
+			}
+		case "runtime":
+			cfg.Runtime = args.Runtime
+		case "numa-aware":
+			cfg.NUMAAware = args.NUMAAware
+		case "devices-headroom":
+			cfg.Devices.Headroom = args.DevicesHeadroom
+		case "opt-in-policy":
+			cfg.OptInPolicy = args.OptInPolicy
+		case "cpu-tuning-policy":
+			cfg.CPUTuningPolicy = args.CPUTuningPolicy
+		case "cfs-quota-policy":
+			cfg.CFSQuotaPolicy = args.CFSQuotaPolicy
+		case "shared-milli-cpus":
+			cfg.SharedMilliCPUs = args.SharedMilliCPUs
+		}
+	})
+	// the mutual cpus are validated once they are read from the file
+	if args.MutualCPUsFile == "" {
+		if err := cfg.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+	}
+
+	args.MutualCPUs = cfg.MutualCPUs
+	args.SharedPools = cfg.Pools()
+	args.Runtime = cfg.Runtime
+	args.NUMAAware = cfg.NUMAAware
+	return cfg, nil
+}
+
+func newClient() (client.Client, error) {
+	cfg, err := ctrlconfig.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(cfg, client.Options{})
+}
+
+func newStatusReporter(mode string, p *nriplugin.Plugin) (*status.Reporter, error) {
+	cli, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	return status.New(cli, os.Getenv("NODE_NAME"), mode, p)
+}
+
+func newEventRecorder() (events.Recorder, error) {
+	nodeName := os.Getenv("NODE_NAME")
+	if nodeName == "" {
+		return nil, fmt.Errorf("node name is required for emitting events")
+	}
+	cli, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	return events.NewNodeRecorder(cli, nodeName), nil
+}
+
 func execute(p *nriplugin.Plugin, dp *dpm.Manager) {
 	go func() {
-		err := p.Stub.Run(context.Background())
+		err := p.Run(context.Background())
 		if err != nil {
 			glog.Fatalf("plugin exited with error %v", err)
 		}
@@ -61,3 +291,15 @@ func execute(p *nriplugin.Plugin, dp *dpm.Manager) {
 
 	dp.Run()
 }
+
+// stringSlice is a flag that can be given multiple times
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+
+	"github.com/golang/glog"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/config"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/deviceplugin"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/webhook"
+)
+
+type Args struct {
+	BindAddress string
+	CertFile    string
+	KeyFile     string
+	ConfigFile  string
+	Inject      bool
+}
+
+func main() {
+	args := parseArgs()
+	if args.CertFile == "" || args.KeyFile == "" {
+		glog.Fatalf("--tls-cert-file and --tls-private-key-file are required")
+	}
+	if args.ConfigFile != "" {
+		cfg, err := config.Load(args.ConfigFile)
+		if err != nil {
+			glog.Fatalf("%v", err)
+		}
+		deviceplugin.SetResourceNames(cfg.Resources.Namespace, cfg.Resources.Name, cfg.Resources.EnvVarName)
+	}
+
+	s := &webhook.Server{Inject: args.Inject}
+	glog.Infof("serving the %q resources admission webhooks on %s", deviceplugin.MutualCPUDeviceName, args.BindAddress)
+	if err := s.ListenAndServeTLS(args.BindAddress, args.CertFile, args.KeyFile); err != nil {
+		glog.Fatalf("webhook server exited with error %v", err)
+	}
+}
+
+func parseArgs() *Args {
+	args := &Args{}
+	flag.StringVar(&args.BindAddress, "bind-address", ":8443", "address to serve the webhooks on")
+	flag.StringVar(&args.CertFile, "tls-cert-file", "", "serving certificate file")
+	flag.StringVar(&args.KeyFile, "tls-private-key-file", "", "serving certificate key file")
+	flag.StringVar(&args.ConfigFile, "config", "", "plugin configuration file, for the names of the resources")
+	flag.BoolVar(&args.Inject, "inject", false, "inject the mutual cpus device into the containers listed in the "+webhook.InjectAnnotation+" pod annotation")
+	flag.Parse()
+	return args
+}
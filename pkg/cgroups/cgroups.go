@@ -17,7 +17,9 @@
 package cgroups
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
 	"strings"
 
 	"github.com/opencontainers/runc/libcontainer/cgroups"
@@ -26,7 +28,9 @@ import (
 
 const (
 	crioPrefix       = "crio"
+	containerdPrefix = "cri-containerd"
 	cgroupMountPoint = "/sys/fs/cgroup"
+	systemdSuffix    = ".slice"
 )
 
 type Mode string
@@ -55,6 +59,55 @@ func init() {
 	}
 }
 
+// Runtime is the container runtime that manages the containers' cgroups.
+// Each runtime names the containers' cgroup directories differently.
+type Runtime string
+
+const (
+	RuntimeCrio       Runtime = "crio"
+	RuntimeContainerd Runtime = "containerd"
+)
+
+// ParseRuntime converts a runtime name, as reported by NRI
+// or given by the user, into a supported Runtime.
+func ParseRuntime(name string) (Runtime, error) {
+	switch strings.ToLower(name) {
+	case "crio", "cri-o":
+		return RuntimeCrio, nil
+	case "containerd":
+		return RuntimeContainerd, nil
+	default:
+		return "", fmt.Errorf("unsupported container runtime %q", name)
+	}
+}
+
+// ErrBurstNotSupported is returned when a burst is requested, but the kernel does not support bursting
+var ErrBurstNotSupported = errors.New("cfs burst is not supported by the kernel")
+
+// CFSQuota holds the CFS bandwidth control settings of a cgroup.
+type CFSQuota struct {
+	// Quota is the allowed run time in microseconds per period.
+	// A negative value means no limit.
+	Quota int64
+	// Period is the length of the period in microseconds.
+	// A zero value leaves the current period of the cgroup untouched.
+	Period uint64
+	// Burst is the run time in microseconds that can be accumulated from
+	// unused quota and spent on top of the quota. The kernel does not allow it beyond the quota,
+	// so it is capped at the quota when written.
+	// A zero value disables bursting, and a non-zero value fails with ErrBurstNotSupported
+	// when the kernel does not support it.
+	Burst uint64
+}
+
+// burstOf returns the burst to write, capped at the quota
+func burstOf(quota CFSQuota) uint64 {
+	if quota.Quota >= 0 && quota.Burst > uint64(quota.Quota) {
+		return uint64(quota.Quota)
+	}
+	return quota.Burst
+}
+
 type adapter struct {
 	mode Mode
 	ai   adapterInterface
@@ -69,19 +122,83 @@ func (a *adapter) GetCFSQuotaPath(processCgroupPath string) (string, error) {
 }
 
 func (a *adapter) GetCrioContainerCFSQuotaPath(parentPath, ctrId string) (string, error) {
-	return a.ai.crioContainerCFSQuotaPath(parentPath, ctrId)
+	return a.GetContainerCFSQuotaPath(parentPath, ctrId, RuntimeCrio)
+}
+
+// GetContainerCFSQuotaPath returns the path of the cfs quota file
+// of the container, according to the naming scheme used by the runtime
+// under the given cgroup parent.
+func (a *adapter) GetContainerCFSQuotaPath(parentPath, ctrId string, runtime Runtime) (string, error) {
+	ctrDir, err := containerCgroupDir(parentPath, ctrId, runtime)
+	if err != nil {
+		return "", err
+	}
+	return a.ai.cfsQuotaPath(filepath.Join(parentPath, ctrDir))
+}
+
+// GetCFSQuota reads the CFS bandwidth settings of the cgroup
+func (a *adapter) GetCFSQuota(processCgroupPath string) (CFSQuota, error) {
+	return a.ai.cfsQuota(processCgroupPath)
+}
+
+// SetCFSQuota writes the CFS bandwidth settings into the cgroup
+func (a *adapter) SetCFSQuota(processCgroupPath string, quota CFSQuota) error {
+	return a.ai.setCFSQuota(processCgroupPath, quota)
+}
+
+// SetContainerCFSQuota writes the CFS bandwidth settings into the container's cgroup,
+// which is located according to the naming scheme used by the runtime.
+func (a *adapter) SetContainerCFSQuota(parentPath, ctrId string, runtime Runtime, quota CFSQuota) error {
+	ctrDir, err := containerCgroupDir(parentPath, ctrId, runtime)
+	if err != nil {
+		return err
+	}
+	return a.ai.setCFSQuota(filepath.Join(parentPath, ctrDir), quota)
 }
 
 type adapterInterface interface {
 	cfsQuotaPath(processCgroupPath string) (string, error)
-	crioContainerCFSQuotaPath(parentPath, ctrId string) (string, error)
+	cfsQuota(processCgroupPath string) (CFSQuota, error)
+	setCFSQuota(processCgroupPath string, quota CFSQuota) error
+}
+
+func isSystemdSlice(path string) bool {
+	return strings.HasSuffix(path, systemdSuffix)
 }
 
 func expandSlice(path string) (string, error) {
 	// systemd fs, otherwise cgroupfs
-	if strings.HasSuffix(path, ".slice") {
+	if isSystemdSlice(path) {
 		return systemd.ExpandSlice(path)
 	}
-	// TODO implement for cgroupfs)
-	return "", fmt.Errorf("cgroupfs not implemented")
+	// a scope under a systemd slice, i.e. a container's cgroup
+	if dir, base := filepath.Split(path); isSystemdSlice(filepath.Clean(dir)) {
+		expanded, err := systemd.ExpandSlice(filepath.Clean(dir))
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(expanded, base), nil
+	}
+	// cgroupfs paths are already relative to the cgroup mount point
+	return filepath.Join("/", path), nil
+}
+
+// containerCgroupDir returns the name of the container's cgroup directory
+// under the pod's cgroup parent.
+func containerCgroupDir(parentPath, ctrId string, runtime Runtime) (string, error) {
+	systemdDriver := isSystemdSlice(parentPath)
+	switch runtime {
+	case RuntimeCrio:
+		if systemdDriver {
+			return crioPrefix + "-" + ctrId + ".scope", nil
+		}
+		return crioPrefix + "-" + ctrId, nil
+	case RuntimeContainerd:
+		if systemdDriver {
+			return containerdPrefix + "-" + ctrId + ".scope", nil
+		}
+		return ctrId, nil
+	default:
+		return "", fmt.Errorf("unsupported container runtime %q", runtime)
+	}
 }
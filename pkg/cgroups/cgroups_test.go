@@ -17,7 +17,12 @@
 package cgroups
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
 )
 
 func TestAdapter_GetCFSQuotaPath(t *testing.T) {
@@ -66,3 +71,326 @@ func TestAdapter_GetCrioContainerCFSQuotaPath(t *testing.T) {
 		}
 	}
 }
+
+func TestAdapter_GetContainerCFSQuotaPath(t *testing.T) {
+	ctrId := "abcdefghijklmnopqrstvuwxyz"
+
+	testCases := []struct {
+		name       string
+		mode       Mode
+		runtime    Runtime
+		parentPath string
+		// relative to the fake cgroup mount point
+		podQuotaPath string
+		ctrQuotaPath string
+	}{
+		{
+			name:         "cgroupv1 systemd crio",
+			mode:         cgroupv1,
+			runtime:      RuntimeCrio,
+			parentPath:   "kubepods-besteffort-pod123.slice",
+			podQuotaPath: "kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod123.slice/cpu.cfs_quota_us",
+			ctrQuotaPath: "kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod123.slice/crio-" + ctrId + ".scope/cpu.cfs_quota_us",
+		},
+		{
+			name:         "cgroupv1 systemd containerd",
+			mode:         cgroupv1,
+			runtime:      RuntimeContainerd,
+			parentPath:   "kubepods-besteffort-pod123.slice",
+			podQuotaPath: "kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod123.slice/cpu.cfs_quota_us",
+			ctrQuotaPath: "kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod123.slice/cri-containerd-" + ctrId + ".scope/cpu.cfs_quota_us",
+		},
+		{
+			name:         "cgroupv1 cgroupfs crio",
+			mode:         cgroupv1,
+			runtime:      RuntimeCrio,
+			parentPath:   "/kubepods/pod123",
+			podQuotaPath: "kubepods/pod123/cpu.cfs_quota_us",
+			ctrQuotaPath: "kubepods/pod123/crio-" + ctrId + "/cpu.cfs_quota_us",
+		},
+		{
+			name:         "cgroupv1 cgroupfs containerd",
+			mode:         cgroupv1,
+			runtime:      RuntimeContainerd,
+			parentPath:   "/kubepods/burstable/pod123",
+			podQuotaPath: "kubepods/burstable/pod123/cpu.cfs_quota_us",
+			ctrQuotaPath: "kubepods/burstable/pod123/" + ctrId + "/cpu.cfs_quota_us",
+		},
+		{
+			name:         "cgroupv2 systemd crio",
+			mode:         cgroupv2UnifiedMode,
+			runtime:      RuntimeCrio,
+			parentPath:   "kubepods-burstable-pod123.slice",
+			podQuotaPath: "kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod123.slice/cpu.max",
+			ctrQuotaPath: "kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod123.slice/crio-" + ctrId + ".scope/cpu.max",
+		},
+		{
+			name:         "cgroupv2 systemd containerd",
+			mode:         cgroupv2UnifiedMode,
+			runtime:      RuntimeContainerd,
+			parentPath:   "kubepods-burstable-pod123.slice",
+			podQuotaPath: "kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod123.slice/cpu.max",
+			ctrQuotaPath: "kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod123.slice/cri-containerd-" + ctrId + ".scope/cpu.max",
+		},
+		{
+			name:         "cgroupv2 cgroupfs crio",
+			mode:         cgroupv2UnifiedMode,
+			runtime:      RuntimeCrio,
+			parentPath:   "/kubepods/pod123",
+			podQuotaPath: "kubepods/pod123/cpu.max",
+			ctrQuotaPath: "kubepods/pod123/crio-" + ctrId + "/cpu.max",
+		},
+		{
+			name:         "cgroupv2 cgroupfs containerd",
+			mode:         cgroupv2UnifiedMode,
+			runtime:      RuntimeContainerd,
+			parentPath:   "/kubepods/besteffort/pod123",
+			podQuotaPath: "kubepods/besteffort/pod123/cpu.max",
+			ctrQuotaPath: "kubepods/besteffort/pod123/" + ctrId + "/cpu.max",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			makeFakeCgroupFile(t, root, tc.podQuotaPath)
+			makeFakeCgroupFile(t, root, tc.ctrQuotaPath)
+			a := makeFakeAdapter(tc.mode, root)
+
+			podQuotaPath, err := a.GetCFSQuotaPath(tc.parentPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filepath.Join(root, tc.podQuotaPath); podQuotaPath != want {
+				t.Errorf("unexpected pod quota path; want: %q got: %q", want, podQuotaPath)
+			}
+			if _, err := os.Stat(podQuotaPath); err != nil {
+				t.Errorf("pod quota path does not exist in the cgroup tree: %v", err)
+			}
+
+			ctrQuotaPath, err := a.GetContainerCFSQuotaPath(tc.parentPath, ctrId, tc.runtime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filepath.Join(root, tc.ctrQuotaPath); ctrQuotaPath != want {
+				t.Errorf("unexpected container quota path; want: %q got: %q", want, ctrQuotaPath)
+			}
+			if _, err := os.Stat(ctrQuotaPath); err != nil {
+				t.Errorf("container quota path does not exist in the cgroup tree: %v", err)
+			}
+		})
+	}
+}
+
+func TestAdapter_GetContainerCFSQuotaPathUnknownRuntime(t *testing.T) {
+	a := makeFakeAdapter(cgroupv2UnifiedMode, t.TempDir())
+	if _, err := a.GetContainerCFSQuotaPath("/kubepods/pod123", "abc", Runtime("foo")); err == nil {
+		t.Errorf("expected error for unknown runtime")
+	}
+}
+
+func TestAdapter_SetCFSQuota(t *testing.T) {
+	parentPath := "kubepods-pod123.slice"
+	ctrId := "abcdefghijklmnopqrstvuwxyz"
+	podDir := "kubepods.slice/kubepods-pod123.slice"
+	ctrDir := podDir + "/crio-" + ctrId + ".scope"
+	// allow writing cgroup files on a non-cgroup filesystem
+	cgroups.TestMode = true
+
+	testCases := []struct {
+		name  string
+		mode  Mode
+		quota CFSQuota
+		// burst is true when the kernel supports bursting
+		burst bool
+		// wantErr is the error of setting the quota, when expected
+		wantErr error
+		// file name to expected content, for both pod and container cgroups
+		want map[string]string
+	}{
+		{
+			name:  "cgroupv1 quota and period",
+			mode:  cgroupv1,
+			quota: CFSQuota{Quota: 300000, Period: 100000},
+			want:  map[string]string{"cpu.cfs_quota_us": "300000", "cpu.cfs_period_us": "100000"},
+		},
+		{
+			name:  "cgroupv1 unlimited keeps period",
+			mode:  cgroupv1,
+			quota: CFSQuota{Quota: -1},
+			want:  map[string]string{"cpu.cfs_quota_us": "-1", "cpu.cfs_period_us": "50000"},
+		},
+		{
+			name:  "cgroupv2 quota and period",
+			mode:  cgroupv2UnifiedMode,
+			quota: CFSQuota{Quota: 300000, Period: 100000},
+			want:  map[string]string{"cpu.max": "300000 100000"},
+		},
+		{
+			name:  "cgroupv2 unlimited",
+			mode:  cgroupv2UnifiedMode,
+			quota: CFSQuota{Quota: -1, Period: 100000},
+			want:  map[string]string{"cpu.max": "max 100000"},
+		},
+		{
+			name:  "cgroupv1 burst",
+			mode:  cgroupv1,
+			quota: CFSQuota{Quota: 200000, Period: 100000, Burst: 100000},
+			burst: true,
+			want:  map[string]string{"cpu.cfs_quota_us": "200000", "cpu.cfs_burst_us": "100000"},
+		},
+		{
+			name:  "cgroupv2 burst",
+			mode:  cgroupv2UnifiedMode,
+			quota: CFSQuota{Quota: 200000, Period: 100000, Burst: 100000},
+			burst: true,
+			want:  map[string]string{"cpu.max": "200000 100000", "cpu.max.burst": "100000"},
+		},
+		{
+			name:  "cgroupv2 burst cleared",
+			mode:  cgroupv2UnifiedMode,
+			quota: CFSQuota{Quota: 200000, Period: 100000},
+			burst: true,
+			want:  map[string]string{"cpu.max": "200000 100000", "cpu.max.burst": "0"},
+		},
+		{
+			name:  "cgroupv2 burst capped at the quota",
+			mode:  cgroupv2UnifiedMode,
+			quota: CFSQuota{Quota: 200000, Period: 100000, Burst: 300000},
+			burst: true,
+			want:  map[string]string{"cpu.max": "200000 100000", "cpu.max.burst": "200000"},
+		},
+		{
+			name:    "cgroupv1 burst not supported",
+			mode:    cgroupv1,
+			quota:   CFSQuota{Quota: 200000, Period: 100000, Burst: 100000},
+			wantErr: ErrBurstNotSupported,
+			// nothing is written
+			want: map[string]string{"cpu.cfs_quota_us": "-1", "cpu.cfs_period_us": "50000"},
+		},
+		{
+			name:    "cgroupv2 burst not supported",
+			mode:    cgroupv2UnifiedMode,
+			quota:   CFSQuota{Quota: 200000, Period: 100000, Burst: 100000},
+			wantErr: ErrBurstNotSupported,
+			want:    map[string]string{"cpu.max": "max 50000"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			for _, dir := range []string{podDir, ctrDir} {
+				if tc.mode == cgroupv1 {
+					writeFakeCgroupFile(t, root, filepath.Join(dir, "cpu.cfs_quota_us"), "-1")
+					writeFakeCgroupFile(t, root, filepath.Join(dir, "cpu.cfs_period_us"), "50000")
+					if tc.burst {
+						writeFakeCgroupFile(t, root, filepath.Join(dir, "cpu.cfs_burst_us"), "5000")
+					}
+				} else {
+					writeFakeCgroupFile(t, root, filepath.Join(dir, "cpu.max"), "max 50000")
+					if tc.burst {
+						writeFakeCgroupFile(t, root, filepath.Join(dir, "cpu.max.burst"), "5000")
+					}
+				}
+			}
+			a := makeFakeAdapter(tc.mode, root)
+
+			if err := a.SetCFSQuota(parentPath, tc.quota); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("unexpected error; want: %v got: %v", tc.wantErr, err)
+			}
+			if err := a.SetContainerCFSQuota(parentPath, ctrId, RuntimeCrio, tc.quota); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("unexpected error; want: %v got: %v", tc.wantErr, err)
+			}
+			for _, dir := range []string{podDir, ctrDir} {
+				if !tc.burst {
+					for _, file := range []string{"cpu.cfs_burst_us", "cpu.max.burst"} {
+						if _, err := os.Stat(filepath.Join(root, dir, file)); err == nil {
+							t.Errorf("unexpected %q under %q", file, dir)
+						}
+					}
+				}
+				for file, want := range tc.want {
+					data, err := os.ReadFile(filepath.Join(root, dir, file))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if string(data) != want {
+						t.Errorf("unexpected %q content under %q; want: %q got: %q", file, dir, want, string(data))
+					}
+				}
+			}
+
+			if tc.wantErr != nil {
+				return
+			}
+			got, err := a.GetCFSQuota(parentPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Quota != tc.quota.Quota {
+				t.Errorf("unexpected quota; want: %d got: %d", tc.quota.Quota, got.Quota)
+			}
+		})
+	}
+}
+
+func TestParseRuntime(t *testing.T) {
+	testCases := []struct {
+		name    string
+		want    Runtime
+		isError bool
+	}{
+		{name: "cri-o", want: RuntimeCrio},
+		{name: "crio", want: RuntimeCrio},
+		{name: "CRI-O", want: RuntimeCrio},
+		{name: "containerd", want: RuntimeContainerd},
+		{name: "docker", isError: true},
+		{name: "", isError: true},
+	}
+
+	for _, tc := range testCases {
+		got, err := ParseRuntime(tc.name)
+		if tc.isError {
+			if err == nil {
+				t.Errorf("expected error for runtime %q", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for runtime %q: %v", tc.name, err)
+		}
+		if got != tc.want {
+			t.Errorf("unexpected runtime for %q; want: %q got: %q", tc.name, tc.want, got)
+		}
+	}
+}
+
+func makeFakeAdapter(mode Mode, root string) *adapter {
+	if mode == cgroupv1 {
+		return &adapter{
+			ai:   &v1Adapter{cpuMountPoint: root},
+			mode: cgroupv1,
+		}
+	}
+	return &adapter{
+		ai:   &v2Adapter{mountPoint: root},
+		mode: cgroupv2UnifiedMode,
+	}
+}
+
+func makeFakeCgroupFile(t *testing.T, root, path string) {
+	t.Helper()
+	writeFakeCgroupFile(t, root, path, "max 100000\n")
+}
+
+func writeFakeCgroupFile(t *testing.T, root, path, data string) {
+	t.Helper()
+	fullPath := filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fullPath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -19,20 +19,36 @@ package cgroups
 import (
 	"fmt"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/opencontainers/runc/libcontainer/cgroups"
 )
 
-type v1Adapter struct{}
+const (
+	cfsQuotaFile  = "cpu.cfs_quota_us"
+	cfsPeriodFile = "cpu.cfs_period_us"
+	cfsBurstFile  = "cpu.cfs_burst_us"
+)
+
+type v1Adapter struct {
+	// cpuMountPoint is the mount point of the cpu controller hierarchy.
+	// when empty, it is looked up under the cgroup mount point.
+	cpuMountPoint string
+}
 
 func (v1 *v1Adapter) absoluteCgroupPath(processCgroupPath string) (string, error) {
-	cpuMountPoint, err := cgroups.FindCgroupMountpoint(cgroupMountPoint, "cpu")
-	if err != nil {
-		return "", fmt.Errorf("%q: failed to find cgroup mount point: %w", cgroupv1, err)
+	cpuMountPoint := v1.cpuMountPoint
+	if cpuMountPoint == "" {
+		var err error
+		cpuMountPoint, err = cgroups.FindCgroupMountpoint(cgroupMountPoint, "cpu")
+		if err != nil {
+			return "", fmt.Errorf("%q: failed to find cgroup mount point: %w", cgroupv1, err)
+		}
 	}
-	processCgroupPath, err = expandSlice(processCgroupPath)
+	processCgroupPath, err := expandSlice(processCgroupPath)
 	if err != nil {
-		return "", fmt.Errorf("%q: systemd failed to expand slice: %w", cgroupv1, err)
+		return "", fmt.Errorf("%q: failed to expand cgroup path: %w", cgroupv1, err)
 	}
 	return filepath.Join(cpuMountPoint, processCgroupPath), nil
 }
@@ -42,13 +58,63 @@ func (v1 *v1Adapter) cfsQuotaPath(processCgroupPath string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(absolutePath, "cpu.cfs_quota_us"), nil
+	return filepath.Join(absolutePath, cfsQuotaFile), nil
 }
 
-func (v1 *v1Adapter) crioContainerCFSQuotaPath(parentPath, ctrId string) (string, error) {
-	parentAbsolutePath, err := v1.absoluteCgroupPath(parentPath)
+func (v1 *v1Adapter) cfsQuota(processCgroupPath string) (CFSQuota, error) {
+	q := CFSQuota{}
+	dir, err := v1.absoluteCgroupPath(processCgroupPath)
 	if err != nil {
-		return "", err
+		return q, err
+	}
+	data, err := cgroups.ReadFile(dir, cfsQuotaFile)
+	if err != nil {
+		return q, fmt.Errorf("%q: failed to read quota: %w", cgroupv1, err)
+	}
+	if q.Quota, err = strconv.ParseInt(strings.TrimSpace(data), 10, 64); err != nil {
+		return q, fmt.Errorf("%q: failed to parse quota %q: %w", cgroupv1, data, err)
+	}
+	data, err = cgroups.ReadFile(dir, cfsPeriodFile)
+	if err != nil {
+		return q, fmt.Errorf("%q: failed to read period: %w", cgroupv1, err)
+	}
+	if q.Period, err = strconv.ParseUint(strings.TrimSpace(data), 10, 64); err != nil {
+		return q, fmt.Errorf("%q: failed to parse period %q: %w", cgroupv1, data, err)
+	}
+	return q, nil
+}
+
+func (v1 *v1Adapter) setCFSQuota(processCgroupPath string, quota CFSQuota) error {
+	dir, err := v1.absoluteCgroupPath(processCgroupPath)
+	if err != nil {
+		return err
+	}
+	burst := cgroups.PathExists(filepath.Join(dir, cfsBurstFile))
+	if !burst && quota.Burst != 0 {
+		return fmt.Errorf("%q: %s not found: %w", cgroupv1, cfsBurstFile, ErrBurstNotSupported)
+	}
+	if quota.Period != 0 {
+		if err := cgroups.WriteFile(dir, cfsPeriodFile, strconv.FormatUint(quota.Period, 10)); err != nil {
+			return fmt.Errorf("%q: failed to set period: %w", cgroupv1, err)
+		}
+	}
+	// the burst must not exceed the quota, so it is cleared before the quota is lowered
+	if burst {
+		if err := cgroups.WriteFile(dir, cfsBurstFile, "0"); err != nil {
+			return fmt.Errorf("%q: failed to clear burst: %w", cgroupv1, err)
+		}
+	}
+	q := quota.Quota
+	if q < 0 {
+		q = -1
+	}
+	if err := cgroups.WriteFile(dir, cfsQuotaFile, strconv.FormatInt(q, 10)); err != nil {
+		return fmt.Errorf("%q: failed to set quota: %w", cgroupv1, err)
+	}
+	if burst && quota.Burst != 0 {
+		if err := cgroups.WriteFile(dir, cfsBurstFile, strconv.FormatUint(burstOf(quota), 10)); err != nil {
+			return fmt.Errorf("%q: failed to set burst: %w", cgroupv1, err)
+		}
 	}
-	return filepath.Join(parentAbsolutePath, crioPrefix+"-"+ctrId+".scope", "cpu.cfs_quota_us"), nil
+	return nil
 }
@@ -19,17 +19,35 @@ package cgroups
 import (
 	"fmt"
 	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+)
+
+const (
+	cpuMaxFile      = "cpu.max"
+	cpuMaxBurstFile = "cpu.max.burst"
+	// cpuMaxUnlimited is the quota value of cpu.max for an unlimited cgroup
+	cpuMaxUnlimited = "max"
 )
 
-type v2Adapter struct{}
+type v2Adapter struct {
+	// mountPoint is the mount point of the unified hierarchy.
+	// when empty, the default cgroup mount point is used.
+	mountPoint string
+}
 
 func (v2 *v2Adapter) absoluteCgroupPath(processCgroupPath string) (string, error) {
-	var err error
-	processCgroupPath, err = expandSlice(processCgroupPath)
+	mountPoint := v2.mountPoint
+	if mountPoint == "" {
+		mountPoint = cgroupMountPoint
+	}
+	processCgroupPath, err := expandSlice(processCgroupPath)
 	if err != nil {
-		return "", fmt.Errorf("%q: systemd failed to expand slice: %w", cgroupv2UnifiedMode, err)
+		return "", fmt.Errorf("%q: failed to expand cgroup path: %w", cgroupv2UnifiedMode, err)
 	}
-	return filepath.Join(cgroupMountPoint, processCgroupPath), nil
+	return filepath.Join(mountPoint, processCgroupPath), nil
 }
 
 func (v2 *v2Adapter) cfsQuotaPath(processCgroupPath string) (string, error) {
@@ -37,13 +55,65 @@ func (v2 *v2Adapter) cfsQuotaPath(processCgroupPath string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(absolutePath, "cpu.max"), nil
+	return filepath.Join(absolutePath, cpuMaxFile), nil
 }
 
-func (v2 *v2Adapter) crioContainerCFSQuotaPath(parentPath, ctrId string) (string, error) {
-	absoluteParentPath, err := v2.absoluteCgroupPath(parentPath)
+func (v2 *v2Adapter) cfsQuota(processCgroupPath string) (CFSQuota, error) {
+	q := CFSQuota{}
+	dir, err := v2.absoluteCgroupPath(processCgroupPath)
 	if err != nil {
-		return "", err
+		return q, err
+	}
+	data, err := cgroups.ReadFile(dir, cpuMaxFile)
+	if err != nil {
+		return q, fmt.Errorf("%q: failed to read %s: %w", cgroupv2UnifiedMode, cpuMaxFile, err)
+	}
+	// cpu.max format is "$MAX $PERIOD"
+	fields := strings.Fields(data)
+	if len(fields) != 2 {
+		return q, fmt.Errorf("%q: unexpected %s format %q", cgroupv2UnifiedMode, cpuMaxFile, data)
+	}
+	if fields[0] == cpuMaxUnlimited {
+		q.Quota = -1
+	} else if q.Quota, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+		return q, fmt.Errorf("%q: failed to parse quota %q: %w", cgroupv2UnifiedMode, fields[0], err)
+	}
+	if q.Period, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+		return q, fmt.Errorf("%q: failed to parse period %q: %w", cgroupv2UnifiedMode, fields[1], err)
+	}
+	return q, nil
+}
+
+func (v2 *v2Adapter) setCFSQuota(processCgroupPath string, quota CFSQuota) error {
+	dir, err := v2.absoluteCgroupPath(processCgroupPath)
+	if err != nil {
+		return err
+	}
+	burst := cgroups.PathExists(filepath.Join(dir, cpuMaxBurstFile))
+	if !burst && quota.Burst != 0 {
+		return fmt.Errorf("%q: %s not found: %w", cgroupv2UnifiedMode, cpuMaxBurstFile, ErrBurstNotSupported)
+	}
+	// the burst must not exceed the quota, so it is cleared before the quota is lowered
+	if burst {
+		if err := cgroups.WriteFile(dir, cpuMaxBurstFile, "0"); err != nil {
+			return fmt.Errorf("%q: failed to clear %s: %w", cgroupv2UnifiedMode, cpuMaxBurstFile, err)
+		}
+	}
+	data := cpuMaxUnlimited
+	if quota.Quota >= 0 {
+		data = strconv.FormatInt(quota.Quota, 10)
+	}
+	// writing only the quota keeps the current period
+	if quota.Period != 0 {
+		data += " " + strconv.FormatUint(quota.Period, 10)
+	}
+	if err := cgroups.WriteFile(dir, cpuMaxFile, data); err != nil {
+		return fmt.Errorf("%q: failed to set %s: %w", cgroupv2UnifiedMode, cpuMaxFile, err)
+	}
+	if burst && quota.Burst != 0 {
+		if err := cgroups.WriteFile(dir, cpuMaxBurstFile, strconv.FormatUint(burstOf(quota), 10)); err != nil {
+			return fmt.Errorf("%q: failed to set %s: %w", cgroupv2UnifiedMode, cpuMaxBurstFile, err)
+		}
 	}
-	return filepath.Join(absoluteParentPath, crioPrefix+"-"+ctrId+".scope", "cpu.max"), nil
+	return nil
 }
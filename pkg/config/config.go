@@ -0,0 +1,279 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+	"sigs.k8s.io/yaml"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/cgroups"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/deviceplugin"
+)
+
+const (
+	APIVersion = "mixedcpus.openshift.io/v1alpha1"
+	Kind       = "PluginConfig"
+
+	// QuotaPolicyProportional sets the cfs quota according to
+	// the number of exclusive and shared cpus of the container
+	QuotaPolicyProportional = "proportional"
+	// QuotaPolicyUnlimited leaves the containers without a cfs quota
+	QuotaPolicyUnlimited = "unlimited"
+	// QuotaPolicyFixed sets the cfs quota according to the number of exclusive cpus
+	// of the container, plus a fixed budget for the shared cpus
+	QuotaPolicyFixed = "fixed"
+	// QuotaPolicyBurst sets the cfs quota according to the number of exclusive cpus
+	// of the container, and lets it burst into the shared cpus with unused quota
+	QuotaPolicyBurst = "burst"
+
+	// OptInDevice gives the shared cpus to the containers that request their device resource
+	OptInDevice = "device"
+	// OptInAnnotation gives the shared cpus to the containers that are listed in the pod's annotations
+	OptInAnnotation = "annotation"
+	// OptInBoth gives the shared cpus to the containers that request them in either way
+	OptInBoth = "both"
+
+	// CPUTuningExclusive adds the shared cpus to containers of pods that tune their cpus
+	// with CRI-O annotations only once the runtime tuned their exclusive cpus
+	CPUTuningExclusive = "exclusive"
+	// CPUTuningReject refuses to create containers with shared cpus in pods
+	// that tune their cpus with CRI-O annotations
+	CPUTuningReject = "reject"
+
+	defaultResourceNamespace = "openshift.io"
+	defaultResourceName      = "mutualcpu"
+	defaultEnvVarName        = "OPENSHIFT_MUTUAL_CPUS"
+	defaultDevicesHeadroom   = 15
+	defaultDevicesLimit      = 1024
+)
+
+// Config is the plugin configuration.
+// It is read from a file, and can be given by the runtime when the plugin connects to it.
+type Config struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// MutualCPUs are the cpus shared by all the containers that request them
+	MutualCPUs string `json:"mutualCPUs,omitempty"`
+	// SharedPools are additional named shared cpus, each exposed as its own resource
+	SharedPools []SharedPool `json:"sharedPools,omitempty"`
+	// NUMAAware gives containers only the shared cpus on the NUMA nodes of their exclusive cpus
+	NUMAAware bool `json:"numaAware,omitempty"`
+	// Runtime is the container runtime; detected when the plugin connects to it when empty
+	Runtime   string    `json:"runtime,omitempty"`
+	Resources Resources `json:"resources,omitempty"`
+	Devices   Devices   `json:"devices,omitempty"`
+	// CFSQuotaPolicy determines the cfs quota of containers with shared cpus:
+	// proportional, unlimited, fixed or burst
+	CFSQuotaPolicy string `json:"cfsQuotaPolicy,omitempty"`
+	// SharedMilliCPUs is the budget, in millicores, that containers get
+	// for their shared cpus under the fixed cfs quota policy
+	SharedMilliCPUs int64 `json:"sharedMilliCPUs,omitempty"`
+	// OptInPolicy determines how containers request the shared cpus: device, annotation or both
+	OptInPolicy string `json:"optInPolicy,omitempty"`
+	// CPUTuningPolicy determines how containers with shared cpus are handled
+	// when their pod tunes its cpus with CRI-O annotations: exclusive or reject
+	CPUTuningPolicy string `json:"cpuTuningPolicy,omitempty"`
+}
+
+type SharedPool struct {
+	Name string `json:"name"`
+	CPUs string `json:"cpus"`
+}
+
+// Resources are the names under which the shared cpus are exposed
+type Resources struct {
+	// Namespace of the device resources, e.g. openshift.io
+	Namespace string `json:"namespace,omitempty"`
+	// Name of the mutual cpus device resource, e.g. mutualcpu.
+	// The pools' resources are named <name>-<pool name>.
+	Name string `json:"name,omitempty"`
+	// EnvVarName is the environment variable that holds the mutual cpus.
+	// The pools' variables are named <env var name>_<POOL NAME>.
+	EnvVarName string `json:"envVarName,omitempty"`
+}
+
+type Devices struct {
+	// Headroom is the number of free devices advertised on top of the allocated ones
+	Headroom int `json:"headroom,omitempty"`
+	// Limit is the maximum number of devices advertised per resource
+	Limit int `json:"limit,omitempty"`
+}
+
+// Default returns the configuration used when no configuration is given
+func Default() *Config {
+	c := &Config{}
+	c.setDefaults()
+	return c
+}
+
+// Load reads and validates the configuration file
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %q: %w", path, err)
+	}
+	c, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %q: %w", path, err)
+	}
+	return c, nil
+}
+
+// Parse decodes and validates a configuration.
+// Unset fields get their default values.
+func Parse(data []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("failed to decode config: %w", err)
+	}
+	c.setDefaults()
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) setDefaults() {
+	if c.APIVersion == "" {
+		c.APIVersion = APIVersion
+	}
+	if c.Kind == "" {
+		c.Kind = Kind
+	}
+	if c.Resources.Namespace == "" {
+		c.Resources.Namespace = defaultResourceNamespace
+	}
+	if c.Resources.Name == "" {
+		c.Resources.Name = defaultResourceName
+	}
+	if c.Resources.EnvVarName == "" {
+		c.Resources.EnvVarName = defaultEnvVarName
+	}
+	if c.Devices.Headroom == 0 {
+		c.Devices.Headroom = defaultDevicesHeadroom
+	}
+	if c.Devices.Limit == 0 {
+		c.Devices.Limit = defaultDevicesLimit
+	}
+	if c.CFSQuotaPolicy == "" {
+		c.CFSQuotaPolicy = QuotaPolicyProportional
+	}
+	if c.OptInPolicy == "" {
+		c.OptInPolicy = OptInDevice
+	}
+	if c.CPUTuningPolicy == "" {
+		c.CPUTuningPolicy = CPUTuningExclusive
+	}
+}
+
+// Validate checks that the configuration is complete and consistent
+func (c *Config) Validate() error {
+	if c.APIVersion != APIVersion {
+		return fmt.Errorf("unsupported apiVersion %q, expected %q", c.APIVersion, APIVersion)
+	}
+	if c.Kind != Kind {
+		return fmt.Errorf("unsupported kind %q, expected %q", c.Kind, Kind)
+	}
+	mutualCPUs, err := cpuset.Parse(c.MutualCPUs)
+	if err != nil {
+		return fmt.Errorf("failed to parse mutualCPUs %q: %w", c.MutualCPUs, err)
+	}
+	if mutualCPUs.IsEmpty() && len(c.SharedPools) == 0 {
+		return fmt.Errorf("there has to be at least one mutual CPU or shared pool")
+	}
+	if _, err := deviceplugin.ParsePools(c.Pools()); err != nil {
+		return err
+	}
+	if c.Runtime != "" {
+		if _, err := cgroups.ParseRuntime(c.Runtime); err != nil {
+			return err
+		}
+	}
+	if errs := validation.IsDNS1123Subdomain(c.Resources.Namespace); len(errs) > 0 {
+		return fmt.Errorf("invalid resources namespace %q: %s", c.Resources.Namespace, strings.Join(errs, "; "))
+	}
+	if errs := validation.IsDNS1123Label(c.Resources.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid resources name %q: %s", c.Resources.Name, strings.Join(errs, "; "))
+	}
+	if errs := validation.IsEnvVarName(c.Resources.EnvVarName); len(errs) > 0 {
+		return fmt.Errorf("invalid resources envVarName %q: %s", c.Resources.EnvVarName, strings.Join(errs, "; "))
+	}
+	if c.Devices.Headroom < 0 {
+		return fmt.Errorf("devices headroom must not be negative, got %d", c.Devices.Headroom)
+	}
+	if c.Devices.Limit < c.Devices.Headroom {
+		return fmt.Errorf("devices limit %d must not be lower than the headroom %d", c.Devices.Limit, c.Devices.Headroom)
+	}
+	if err := ValidateQuotaPolicy(c.CFSQuotaPolicy); err != nil {
+		return err
+	}
+	if c.SharedMilliCPUs < 0 {
+		return fmt.Errorf("sharedMilliCPUs must not be negative, got %d", c.SharedMilliCPUs)
+	}
+	if c.CFSQuotaPolicy == QuotaPolicyFixed && c.SharedMilliCPUs == 0 {
+		return fmt.Errorf("cfsQuotaPolicy %q requires sharedMilliCPUs", QuotaPolicyFixed)
+	}
+	if err := ValidateOptInPolicy(c.OptInPolicy); err != nil {
+		return err
+	}
+	if err := ValidateCPUTuningPolicy(c.CPUTuningPolicy); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateQuotaPolicy checks that the cfs quota policy is supported
+func ValidateQuotaPolicy(policy string) error {
+	switch policy {
+	case QuotaPolicyProportional, QuotaPolicyUnlimited, QuotaPolicyFixed, QuotaPolicyBurst:
+		return nil
+	}
+	return fmt.Errorf("unsupported cfsQuotaPolicy %q, expected %q, %q, %q or %q",
+		policy, QuotaPolicyProportional, QuotaPolicyUnlimited, QuotaPolicyFixed, QuotaPolicyBurst)
+}
+
+// ValidateOptInPolicy checks that the opt-in policy is supported
+func ValidateOptInPolicy(policy string) error {
+	switch policy {
+	case OptInDevice, OptInAnnotation, OptInBoth:
+		return nil
+	}
+	return fmt.Errorf("unsupported optInPolicy %q, expected %q, %q or %q", policy, OptInDevice, OptInAnnotation, OptInBoth)
+}
+
+// ValidateCPUTuningPolicy checks that the cpu tuning policy is supported
+func ValidateCPUTuningPolicy(policy string) error {
+	switch policy {
+	case CPUTuningExclusive, CPUTuningReject:
+		return nil
+	}
+	return fmt.Errorf("unsupported cpuTuningPolicy %q, expected %q or %q", policy, CPUTuningExclusive, CPUTuningReject)
+}
+
+// Pools returns the shared pools in the format of <name>:<cpus>
+func (c *Config) Pools() []string {
+	var pools []string
+	for _, p := range c.SharedPools {
+		pools = append(pools, p.Name+":"+p.CPUs)
+	}
+	return pools
+}
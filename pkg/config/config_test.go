@@ -0,0 +1,187 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name    string
+		data    string
+		want    *Config
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			data: `mutualCPUs: "0-1"`,
+			want: &Config{
+				APIVersion:      APIVersion,
+				Kind:            Kind,
+				MutualCPUs:      "0-1",
+				Resources:       Resources{Namespace: "openshift.io", Name: "mutualcpu", EnvVarName: "OPENSHIFT_MUTUAL_CPUS"},
+				Devices:         Devices{Headroom: 15, Limit: 1024},
+				CFSQuotaPolicy:  QuotaPolicyProportional,
+				OptInPolicy:     OptInDevice,
+				CPUTuningPolicy: CPUTuningExclusive,
+			},
+		},
+		{
+			name: "full",
+			data: `apiVersion: mixedcpus.openshift.io/v1alpha1
+kind: PluginConfig
+mutualCPUs: "0"
+sharedPools:
+- name: io
+  cpus: "2-3"
+numaAware: true
+runtime: crio
+resources:
+  namespace: example.com
+  name: sharedcpu
+  envVarName: SHARED_CPUS
+devices:
+  headroom: 4
+  limit: 64
+cfsQuotaPolicy: fixed
+sharedMilliCPUs: 500
+optInPolicy: both
+cpuTuningPolicy: reject
+`,
+			want: &Config{
+				APIVersion:      APIVersion,
+				Kind:            Kind,
+				MutualCPUs:      "0",
+				SharedPools:     []SharedPool{{Name: "io", CPUs: "2-3"}},
+				NUMAAware:       true,
+				Runtime:         "crio",
+				Resources:       Resources{Namespace: "example.com", Name: "sharedcpu", EnvVarName: "SHARED_CPUS"},
+				Devices:         Devices{Headroom: 4, Limit: 64},
+				CFSQuotaPolicy:  QuotaPolicyFixed,
+				SharedMilliCPUs: 500,
+				OptInPolicy:     OptInBoth,
+				CPUTuningPolicy: CPUTuningReject,
+			},
+		},
+		{
+			name:    "unknown field",
+			data:    "mutualCPUs: \"0\"\nsharedCPUs: \"1\"",
+			wantErr: "unknown field",
+		},
+		{
+			name:    "wrong apiVersion",
+			data:    "apiVersion: v1\nmutualCPUs: \"0\"",
+			wantErr: "unsupported apiVersion",
+		},
+		{
+			name:    "no shared cpus",
+			data:    "runtime: crio",
+			wantErr: "at least one mutual CPU or shared pool",
+		},
+		{
+			name:    "invalid cpus",
+			data:    `mutualCPUs: "a-b"`,
+			wantErr: "failed to parse mutualCPUs",
+		},
+		{
+			name:    "invalid runtime",
+			data:    "mutualCPUs: \"0\"\nruntime: docker",
+			wantErr: "docker",
+		},
+		{
+			name:    "invalid resource name",
+			data:    "mutualCPUs: \"0\"\nresources:\n  name: Mutual/CPU",
+			wantErr: "invalid resources name",
+		},
+		{
+			name:    "invalid env var name",
+			data:    "mutualCPUs: \"0\"\nresources:\n  envVarName: 1CPUS",
+			wantErr: "invalid resources envVarName",
+		},
+		{
+			name:    "limit lower than headroom",
+			data:    "mutualCPUs: \"0\"\ndevices:\n  headroom: 20\n  limit: 10",
+			wantErr: "must not be lower than the headroom",
+		},
+		{
+			name:    "unsupported quota policy",
+			data:    "mutualCPUs: \"0\"\ncfsQuotaPolicy: none",
+			wantErr: "unsupported cfsQuotaPolicy",
+		},
+		{
+			name:    "fixed quota policy without a budget",
+			data:    "mutualCPUs: \"0\"\ncfsQuotaPolicy: fixed",
+			wantErr: "requires sharedMilliCPUs",
+		},
+		{
+			name:    "negative shared budget",
+			data:    "mutualCPUs: \"0\"\ncfsQuotaPolicy: burst\nsharedMilliCPUs: -1",
+			wantErr: "must not be negative",
+		},
+		{
+			name:    "unsupported opt-in policy",
+			data:    "mutualCPUs: \"0\"\noptInPolicy: label",
+			wantErr: "unsupported optInPolicy",
+		},
+		{
+			name:    "unsupported cpu tuning policy",
+			data:    "mutualCPUs: \"0\"\ncpuTuningPolicy: ignore",
+			wantErr: "unsupported cpuTuningPolicy",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Parse([]byte(tc.data))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("unexpected config; want: %+v got: %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("mutualCPUs: \"1\"\nsharedPools:\n- name: io\n  cpus: \"2\""), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.Pools(), []string{"io:2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected pools; want: %v got: %v", want, got)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatalf("expected an error for a missing config file")
+	}
+}
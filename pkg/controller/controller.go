@@ -0,0 +1,210 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package controller deploys the plugin's DaemonSet of each node pool with the pool's shared cpus,
+// which are derived from the pool's PerformanceProfile or from its kubelet's reserved cpus.
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/golang/glog"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/manifests"
+)
+
+// Reconciler brings the plugin's objects of a node pool to the desired state
+type Reconciler interface {
+	Reconcile(ctx context.Context, pool string) error
+}
+
+// Controller reconciles the pools that are queued by the informers' events.
+// Failed pools are requeued with a backoff.
+type Controller struct {
+	name       string
+	reconciler Reconciler
+	queue      workqueue.RateLimitingInterface
+	synced     []cache.InformerSynced
+}
+
+func newController(name string, r Reconciler) *Controller {
+	return &Controller{
+		name:       name,
+		reconciler: r,
+		queue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), name),
+	}
+}
+
+func (c *Controller) enqueue(pool string) {
+	c.queue.Add(pool)
+}
+
+// Run waits for the informers to sync, and then reconciles the queued pools until the context is done
+func (c *Controller) Run(ctx context.Context) error {
+	defer c.queue.ShutDown()
+	if !cache.WaitForNamedCacheSync(c.name, ctx.Done(), c.synced...) {
+		return fmt.Errorf("%s: failed to sync informers", c.name)
+	}
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		for c.processNext(ctx) {
+		}
+	}, time.Second)
+	<-ctx.Done()
+	return nil
+}
+
+func (c *Controller) processNext(ctx context.Context) bool {
+	item, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(item)
+	pool := item.(string)
+	if err := c.reconciler.Reconcile(ctx, pool); err != nil {
+		glog.Errorf("%s: failed to reconcile pool %q: %v", c.name, pool, err)
+		c.queue.AddRateLimited(item)
+		return true
+	}
+	c.queue.Forget(item)
+	return true
+}
+
+// Renderer renders the plugin's manifests of a node pool
+type Renderer struct {
+	// Namespace the plugin is deployed in
+	Namespace string
+	// Options are applied to the manifests of all the pools, e.g. manifests.WithServiceMonitor()
+	Options []func(mf *manifests.Manifests)
+}
+
+// PoolName returns the name of the plugin's objects of the pool
+func PoolName(pool string) string {
+	return "mixedcpus-plugin-" + pool
+}
+
+func (r *Renderer) render(pool, sharedCPUs string, nodeSelector map[string]string) (*manifests.Manifests, error) {
+	opts := []func(mf *manifests.Manifests){
+		manifests.WithNamespace(r.Namespace),
+		manifests.WithName(PoolName(pool)),
+		manifests.WithPool(pool, nodeSelector),
+	}
+	mf, err := manifests.Get(sharedCPUs, append(opts, r.Options...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render pool %q manifests: %w", pool, err)
+	}
+	return mf, nil
+}
+
+// apply creates the objects, or updates them if they exist.
+// The objects are controlled by the owner, when given.
+func apply(ctx context.Context, cli client.Client, owner metav1.Object, objs []client.Object) error {
+	for _, obj := range objs {
+		if owner != nil {
+			if err := controllerutil.SetControllerReference(owner, obj, cli.Scheme()); err != nil {
+				return err
+			}
+		}
+		key := client.ObjectKeyFromObject(obj)
+		kind := obj.GetObjectKind().GroupVersionKind().Kind
+		existing := obj.DeepCopyObject().(client.Object)
+		err := cli.Get(ctx, key, existing)
+		if apierrors.IsNotFound(err) {
+			if err := cli.Create(ctx, obj); err != nil {
+				return fmt.Errorf("failed to create %s %q: %w", kind, key.String(), err)
+			}
+			glog.Infof("created %s %q", kind, key.String())
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get %s %q: %w", kind, key.String(), err)
+		}
+		obj.SetResourceVersion(existing.GetResourceVersion())
+		if err := cli.Update(ctx, obj); err != nil {
+			return fmt.Errorf("failed to update %s %q: %w", kind, key.String(), err)
+		}
+		glog.V(4).Infof("updated %s %q", kind, key.String())
+	}
+	return nil
+}
+
+// remove deletes the objects, for cleaning up the objects of pools that are gone.
+// The namespace is shared by all the pools, so it is kept.
+func remove(ctx context.Context, cli client.Client, objs []client.Object) error {
+	for _, obj := range objs {
+		if _, ok := obj.(*corev1.Namespace); ok {
+			continue
+		}
+		key := client.ObjectKeyFromObject(obj)
+		kind := obj.GetObjectKind().GroupVersionKind().Kind
+		if err := cli.Delete(ctx, obj); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("failed to delete %s %q: %w", kind, key.String(), err)
+		}
+		glog.Infof("deleted %s %q", kind, key.String())
+	}
+	return nil
+}
+
+// generationChanged reports updates of an object's spec, so status updates are ignored
+func generationChanged(old, new interface{}) bool {
+	o, ok := old.(metav1.Object)
+	if !ok {
+		return true
+	}
+	n, ok := new.(metav1.Object)
+	if !ok {
+		return true
+	}
+	return o.GetGeneration() != n.GetGeneration()
+}
+
+// eventHandler queues the pools returned by poolsOf on the events of an object.
+// Updates are ignored unless changed reports them, when given.
+func eventHandler(c *Controller, poolsOf func(obj interface{}) []string, changed func(old, new interface{}) bool) cache.ResourceEventHandler {
+	enqueue := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		for _, pool := range poolsOf(obj) {
+			c.enqueue(pool)
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(old, obj interface{}) {
+			if changed != nil && !changed(old, obj) {
+				return
+			}
+			// the pools of the old object are reconciled as well, e.g. when a node moves between pools
+			enqueue(old)
+			enqueue(obj)
+		},
+		DeleteFunc: enqueue,
+	}
+}
@@ -0,0 +1,345 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	securityv1 "github.com/openshift/api/security/v1"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/manifests"
+)
+
+const testNamespace = "mixedcpus-plugin"
+
+func newFakeClient(t *testing.T, objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	for _, add := range []func(*runtime.Scheme) error{
+		corev1.AddToScheme,
+		appsv1.AddToScheme,
+		rbacv1.AddToScheme,
+		securityv1.AddToScheme,
+	} {
+		if err := add(scheme); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func newProfile(name string, cpu map[string]interface{}, nodeSelector map[string]interface{}) *unstructured.Unstructured {
+	spec := map[string]interface{}{"cpu": cpu}
+	if nodeSelector != nil {
+		spec["nodeSelector"] = nodeSelector
+	}
+	profile := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	profile.SetGroupVersionKind(PerformanceProfileGVK)
+	profile.SetName(name)
+	profile.SetUID("1234")
+	return profile
+}
+
+func getDaemonSet(t *testing.T, cli client.Client, pool string) *appsv1.DaemonSet {
+	ds := &appsv1.DaemonSet{}
+	if err := cli.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: PoolName(pool)}, ds); err != nil {
+		t.Fatalf("failed to get pool %q DaemonSet: %v", pool, err)
+	}
+	return ds
+}
+
+func mutualCPUsArg(ds *appsv1.DaemonSet) string {
+	for _, arg := range ds.Spec.Template.Spec.Containers[0].Args {
+		if strings.HasPrefix(arg, "--mutual-cpus=") {
+			return strings.TrimPrefix(arg, "--mutual-cpus=")
+		}
+	}
+	return ""
+}
+
+func TestPerformanceProfileReconcile(t *testing.T) {
+	testCases := []struct {
+		name string
+		cpu  map[string]interface{}
+		want string
+	}{
+		{
+			name: "shared cpus",
+			cpu:  map[string]interface{}{"reserved": "0-1", "shared": "2-3", "isolated": "4-7"},
+			want: "2-3",
+		},
+		{
+			name: "reserved cpus",
+			cpu:  map[string]interface{}{"reserved": "0-1", "isolated": "2-7"},
+			want: "0-1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			nodeSelector := map[string]interface{}{"node-role.kubernetes.io/worker-cnf": ""}
+			cli := newFakeClient(t, newProfile("cnf", tc.cpu, nodeSelector))
+			r := &PerformanceProfileReconciler{Client: cli, Renderer: Renderer{Namespace: testNamespace}}
+			if err := r.Reconcile(context.Background(), "cnf"); err != nil {
+				t.Fatal(err)
+			}
+
+			ds := getDaemonSet(t, cli, "cnf")
+			if got := mutualCPUsArg(ds); got != tc.want {
+				t.Errorf("unexpected mutual cpus; want: %q got: %q", tc.want, got)
+			}
+			if got := ds.Spec.Template.Spec.NodeSelector; !reflect.DeepEqual(got, map[string]string{"node-role.kubernetes.io/worker-cnf": ""}) {
+				t.Errorf("unexpected node selector %v", got)
+			}
+			if got := ds.Spec.Template.Labels[manifests.PoolLabel]; got != "cnf" {
+				t.Errorf("unexpected pool label %q", got)
+			}
+			owner := metav1.GetControllerOf(ds)
+			if owner == nil || owner.Kind != PerformanceProfileGVK.Kind || owner.Name != "cnf" {
+				t.Errorf("expected the DaemonSet to be controlled by the profile, got: %+v", owner)
+			}
+			scc := &securityv1.SecurityContextConstraints{}
+			if err := cli.Get(context.Background(), client.ObjectKey{Name: PoolName("cnf")}, scc); err != nil {
+				t.Errorf("failed to get the pool's SecurityContextConstraints: %v", err)
+			}
+		})
+	}
+}
+
+func TestPerformanceProfileReconcileUpdate(t *testing.T) {
+	profile := newProfile("cnf", map[string]interface{}{"reserved": "0-1"}, nil)
+	cli := newFakeClient(t, profile)
+	r := &PerformanceProfileReconciler{Client: cli, Renderer: Renderer{Namespace: testNamespace}}
+	if err := r.Reconcile(context.Background(), "cnf"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cli.Get(context.Background(), client.ObjectKey{Name: "cnf"}, profile); err != nil {
+		t.Fatal(err)
+	}
+	if err := unstructured.SetNestedField(profile.Object, "0-3", "spec", "cpu", "reserved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Update(context.Background(), profile); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reconcile(context.Background(), "cnf"); err != nil {
+		t.Fatal(err)
+	}
+	if got := mutualCPUsArg(getDaemonSet(t, cli, "cnf")); got != "0-3" {
+		t.Fatalf("unexpected mutual cpus; want: %q got: %q", "0-3", got)
+	}
+}
+
+func TestPerformanceProfileReconcileNoCPUs(t *testing.T) {
+	cli := newFakeClient(t, newProfile("cnf", map[string]interface{}{"isolated": "0-7"}, nil))
+	r := &PerformanceProfileReconciler{Client: cli, Renderer: Renderer{Namespace: testNamespace}}
+	// not retried until the profile changes
+	if err := r.Reconcile(context.Background(), "cnf"); err != nil {
+		t.Fatal(err)
+	}
+	// a deleted profile has nothing to reconcile
+	if err := r.Reconcile(context.Background(), "deleted"); err != nil {
+		t.Fatal(err)
+	}
+	dss := &appsv1.DaemonSetList{}
+	if err := cli.List(context.Background(), dss); err != nil {
+		t.Fatal(err)
+	}
+	if len(dss.Items) != 0 {
+		t.Fatalf("expected no DaemonSets, got: %d", len(dss.Items))
+	}
+}
+
+func newNode(name, pool string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   name,
+		Labels: map[string]string{"pool": pool},
+	}}
+}
+
+func TestKubeletReconcile(t *testing.T) {
+	testCases := []struct {
+		name     string
+		reserved map[string]string
+		want     string
+		isError  bool
+	}{
+		{
+			name:     "same reserved cpus",
+			reserved: map[string]string{"node-0": "0,1", "node-1": "0-1"},
+			want:     "0-1",
+		},
+		{
+			name:     "nodes without reserved cpus are skipped",
+			reserved: map[string]string{"node-0": "", "node-1": "0-1"},
+			want:     "0-1",
+		},
+		{
+			name:     "different reserved cpus",
+			reserved: map[string]string{"node-0": "0", "node-1": "0-1"},
+			isError:  true,
+		},
+		{
+			name:     "no reserved cpus",
+			reserved: map[string]string{"node-0": "", "node-1": ""},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cli := newFakeClient(t, newNode("node-0", "cnf"), newNode("node-1", "cnf"), newNode("node-2", "other"))
+			r := &KubeletReconciler{
+				Client:    cli,
+				Renderer:  Renderer{Namespace: testNamespace},
+				PoolLabel: "pool",
+				ReservedCPUs: func(ctx context.Context, nodeName string) (string, error) {
+					cpus, ok := tc.reserved[nodeName]
+					if !ok {
+						return "", fmt.Errorf("unexpected node %q", nodeName)
+					}
+					return cpus, nil
+				},
+			}
+			err := r.Reconcile(context.Background(), "cnf")
+			if tc.isError {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.want == "" {
+				dss := &appsv1.DaemonSetList{}
+				if err := cli.List(context.Background(), dss); err != nil {
+					t.Fatal(err)
+				}
+				if len(dss.Items) != 0 {
+					t.Fatalf("expected no DaemonSets, got: %d", len(dss.Items))
+				}
+				return
+			}
+			ds := getDaemonSet(t, cli, "cnf")
+			if got := mutualCPUsArg(ds); got != tc.want {
+				t.Errorf("unexpected mutual cpus; want: %q got: %q", tc.want, got)
+			}
+			if got := ds.Spec.Template.Spec.NodeSelector; !reflect.DeepEqual(got, map[string]string{"pool": "cnf"}) {
+				t.Errorf("unexpected node selector %v", got)
+			}
+		})
+	}
+}
+
+func TestKubeletReconcileRemovedPool(t *testing.T) {
+	reserved := map[string]string{"node-0": "0-1"}
+	cli := newFakeClient(t, newNode("node-0", "cnf"))
+	r := &KubeletReconciler{
+		Client:    cli,
+		Renderer:  Renderer{Namespace: testNamespace},
+		PoolLabel: "pool",
+		ReservedCPUs: func(ctx context.Context, nodeName string) (string, error) {
+			return reserved[nodeName], nil
+		},
+	}
+	if err := r.Reconcile(context.Background(), "cnf"); err != nil {
+		t.Fatal(err)
+	}
+	getDaemonSet(t, cli, "cnf")
+
+	// the node moves to another pool, leaving the pool empty
+	node := &corev1.Node{}
+	if err := cli.Get(context.Background(), client.ObjectKey{Name: "node-0"}, node); err != nil {
+		t.Fatal(err)
+	}
+	node.Labels["pool"] = "other"
+	if err := cli.Update(context.Background(), node); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reconcile(context.Background(), "cnf"); err != nil {
+		t.Fatal(err)
+	}
+	dss := &appsv1.DaemonSetList{}
+	if err := cli.List(context.Background(), dss); err != nil {
+		t.Fatal(err)
+	}
+	if len(dss.Items) != 0 {
+		t.Fatalf("expected the pool's DaemonSet to be removed, got: %d", len(dss.Items))
+	}
+	scc := &securityv1.SecurityContextConstraints{}
+	if err := cli.Get(context.Background(), client.ObjectKey{Name: PoolName("cnf")}, scc); err == nil {
+		t.Fatalf("expected the pool's SecurityContextConstraints to be removed")
+	}
+	// nothing is left to remove
+	if err := r.Reconcile(context.Background(), "cnf"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerationChanged(t *testing.T) {
+	ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "ds", Generation: 1}}
+	status := ds.DeepCopy()
+	status.Status.NumberReady = 1
+	if generationChanged(ds, status) {
+		t.Errorf("expected status updates to be ignored")
+	}
+	spec := ds.DeepCopy()
+	spec.Generation = 2
+	if !generationChanged(ds, spec) {
+		t.Errorf("expected spec updates to be reported")
+	}
+}
+
+func TestPoolOfDaemonSet(t *testing.T) {
+	mf, err := manifests.Get("0", manifests.WithPool("cnf", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := poolOfDaemonSet(&mf.DS); !reflect.DeepEqual(got, []string{"cnf"}) {
+		t.Errorf("unexpected pool of the pool's DaemonSet; want: %v got: %v", []string{"cnf"}, got)
+	}
+	if got := poolOfDaemonSet(&appsv1.DaemonSet{}); got != nil {
+		t.Errorf("expected no pool for a DaemonSet without a selector, got: %v", got)
+	}
+}
+
+func TestParseConfigz(t *testing.T) {
+	got, err := parseConfigz([]byte(`{"kubeletconfig":{"cpuManagerPolicy":"static","reservedSystemCPUs":"0-1"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "0-1" {
+		t.Fatalf("unexpected reserved cpus; want: %q got: %q", "0-1", got)
+	}
+	if _, err := parseConfigz([]byte("not json")); err == nil {
+		t.Fatalf("expected an error for an invalid configz")
+	}
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/golang/glog"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/manifests"
+)
+
+// KubeletReconciler deploys the plugin on each node pool, which is the nodes
+// sharing a value of PoolLabel. The shared cpus are the kubelet's reservedSystemCPUs,
+// which have to be the same on all the nodes of the pool.
+// The objects of pools that are left without such nodes are removed.
+type KubeletReconciler struct {
+	Client client.Client
+	Renderer
+	// PoolLabel is the node label whose values are the node pools
+	PoolLabel string
+	// ReservedCPUs returns the reservedSystemCPUs of the node's kubelet
+	ReservedCPUs func(ctx context.Context, nodeName string) (string, error)
+}
+
+// Reconcile deploys the plugin on the nodes of the pool
+func (r *KubeletReconciler) Reconcile(ctx context.Context, pool string) error {
+	nodes := &corev1.NodeList{}
+	if err := r.Client.List(ctx, nodes, client.MatchingLabels{r.PoolLabel: pool}); err != nil {
+		return err
+	}
+
+	var reserved cpuset.CPUSet
+	var first string
+	for _, node := range nodes.Items {
+		cpus, err := r.ReservedCPUs(ctx, node.Name)
+		if err != nil {
+			return fmt.Errorf("failed to get node %q reserved cpus: %w", node.Name, err)
+		}
+		set, err := cpuset.Parse(cpus)
+		if err != nil {
+			return fmt.Errorf("failed to parse node %q reserved cpus %q: %w", node.Name, cpus, err)
+		}
+		if set.IsEmpty() {
+			glog.Warningf("pool %q: node %q kubelet has no reservedSystemCPUs; skipping it", pool, node.Name)
+			continue
+		}
+		if first == "" {
+			reserved, first = set, node.Name
+			continue
+		}
+		if !set.Equals(reserved) {
+			return fmt.Errorf("pool %q: node %q reserved cpus %q differ from node %q reserved cpus %q",
+				pool, node.Name, set.String(), first, reserved.String())
+		}
+	}
+	if first == "" {
+		// the pool's objects have no owner to be garbage collected along with
+		glog.Infof("pool %q: no nodes with reserved cpus, removing the pool's objects", pool)
+		// the names of the objects do not depend on the shared cpus
+		mf, err := r.render(pool, "", nil)
+		if err != nil {
+			return err
+		}
+		return remove(ctx, r.Client, mf.ToObjects())
+	}
+
+	mf, err := r.render(pool, reserved.String(), map[string]string{r.PoolLabel: pool})
+	if err != nil {
+		return err
+	}
+	glog.Infof("pool %q: shared cpus %q", pool, reserved.String())
+	return apply(ctx, r.Client, nil, mf.ToObjects())
+}
+
+// poolsOf returns the pool of a node
+func (r *KubeletReconciler) poolsOf(obj interface{}) []string {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return nil
+	}
+	if pool := node.Labels[r.PoolLabel]; pool != "" {
+		return []string{pool}
+	}
+	return nil
+}
+
+// poolOfDaemonSet returns the pool of a DaemonSet deployed by the reconciler
+func poolOfDaemonSet(obj interface{}) []string {
+	ds, ok := obj.(*appsv1.DaemonSet)
+	if !ok || ds.Spec.Selector == nil {
+		return nil
+	}
+	if pool := ds.Spec.Selector.MatchLabels[manifests.PoolLabel]; pool != "" {
+		return []string{pool}
+	}
+	return nil
+}
+
+// NewKubeletController reconciles the pools when nodes are added, removed or relabeled.
+// Node status updates are ignored, so the kubelets are not queried on every heartbeat.
+// The pools of the existing DaemonSets are reconciled as well, so the DaemonSets of pools
+// that were removed while the controller was down are cleaned up, and so are the specs changed by others.
+func NewKubeletController(ctx context.Context, r *KubeletReconciler, cs kubernetes.Interface) *Controller {
+	c := newController("kubelet", r)
+	nodes := informers.NewSharedInformerFactory(cs, 0).Core().V1().Nodes().Informer()
+	nodes.AddEventHandler(eventHandler(c, r.poolsOf, func(old, new interface{}) bool {
+		return !reflect.DeepEqual(old.(*corev1.Node).Labels, new.(*corev1.Node).Labels)
+	}))
+	daemonSets := informers.NewSharedInformerFactoryWithOptions(cs, 0, informers.WithNamespace(r.Namespace)).Apps().V1().DaemonSets().Informer()
+	daemonSets.AddEventHandler(eventHandler(c, poolOfDaemonSet, generationChanged))
+	go nodes.Run(ctx.Done())
+	go daemonSets.Run(ctx.Done())
+	c.synced = append(c.synced, nodes.HasSynced, daemonSets.HasSynced)
+	return c
+}
+
+// KubeletReservedCPUs returns a function that reads the kubelet's reservedSystemCPUs
+// from its configz endpoint, through the API server's node proxy.
+func KubeletReservedCPUs(cs kubernetes.Interface) func(ctx context.Context, nodeName string) (string, error) {
+	return func(ctx context.Context, nodeName string) (string, error) {
+		data, err := cs.CoreV1().RESTClient().Get().AbsPath("/api/v1/nodes", nodeName, "proxy", "configz").DoRaw(ctx)
+		if err != nil {
+			return "", err
+		}
+		return parseConfigz(data)
+	}
+}
+
+func parseConfigz(data []byte) (string, error) {
+	configz := struct {
+		KubeletConfig struct {
+			ReservedSystemCPUs string `json:"reservedSystemCPUs"`
+		} `json:"kubeletconfig"`
+	}{}
+	if err := json.Unmarshal(data, &configz); err != nil {
+		return "", fmt.Errorf("failed to decode kubelet configz: %w", err)
+	}
+	return configz.KubeletConfig.ReservedSystemCPUs, nil
+}
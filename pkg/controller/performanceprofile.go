@@ -0,0 +1,123 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/golang/glog"
+)
+
+var (
+	// PerformanceProfileGVK is the OpenShift PerformanceProfile, which defines the cpus of a node pool
+	PerformanceProfileGVK = schema.GroupVersionKind{Group: "performance.openshift.io", Version: "v2", Kind: "PerformanceProfile"}
+	performanceProfileGVR = PerformanceProfileGVK.GroupVersion().WithResource("performanceprofiles")
+)
+
+// PerformanceProfileReconciler deploys the plugin on the nodes of each PerformanceProfile.
+// The shared cpus are the profile's shared cpus, or its reserved cpus when there are none.
+// The plugin's objects are owned by the profile, so they are removed along with it.
+type PerformanceProfileReconciler struct {
+	Client client.Client
+	Renderer
+}
+
+// Reconcile deploys the plugin on the nodes of the profile named pool
+func (r *PerformanceProfileReconciler) Reconcile(ctx context.Context, pool string) error {
+	profile := &unstructured.Unstructured{}
+	profile.SetGroupVersionKind(PerformanceProfileGVK)
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: pool}, profile); err != nil {
+		if apierrors.IsNotFound(err) {
+			// the objects of a deleted profile are garbage collected
+			return nil
+		}
+		return err
+	}
+	sharedCPUs, err := profileSharedCPUs(profile)
+	if err != nil {
+		// nothing to do until the profile changes
+		glog.Errorf("PerformanceProfile %q: %v", pool, err)
+		return nil
+	}
+	nodeSelector, _, err := unstructured.NestedStringMap(profile.Object, "spec", "nodeSelector")
+	if err != nil {
+		glog.Errorf("PerformanceProfile %q: invalid node selector: %v", pool, err)
+		return nil
+	}
+	mf, err := r.render(pool, sharedCPUs, nodeSelector)
+	if err != nil {
+		return err
+	}
+	glog.Infof("PerformanceProfile %q: shared cpus %q", pool, sharedCPUs)
+	return apply(ctx, r.Client, profile, mf.ToObjects())
+}
+
+// profileSharedCPUs returns the profile's shared cpus, or its reserved cpus when there are none
+func profileSharedCPUs(profile *unstructured.Unstructured) (string, error) {
+	for _, field := range []string{"shared", "reserved"} {
+		cpus, _, err := unstructured.NestedString(profile.Object, "spec", "cpu", field)
+		if err != nil {
+			return "", fmt.Errorf("invalid spec.cpu.%s: %w", field, err)
+		}
+		if cpus != "" {
+			return cpus, nil
+		}
+	}
+	return "", fmt.Errorf("neither spec.cpu.shared nor spec.cpu.reserved is set")
+}
+
+// NewPerformanceProfileController reconciles the profiles when they change,
+// and when the specs of the DaemonSets they own are changed by others.
+func NewPerformanceProfileController(ctx context.Context, r *PerformanceProfileReconciler, cs kubernetes.Interface, dyn dynamic.Interface) *Controller {
+	c := newController("performanceprofile", r)
+
+	profiles := dynamicinformer.NewDynamicSharedInformerFactory(dyn, 0).ForResource(performanceProfileGVR).Informer()
+	profiles.AddEventHandler(eventHandler(c, func(obj interface{}) []string {
+		if o, ok := obj.(metav1.Object); ok {
+			return []string{o.GetName()}
+		}
+		return nil
+	}, nil))
+
+	daemonSets := informers.NewSharedInformerFactoryWithOptions(cs, 0, informers.WithNamespace(r.Namespace)).Apps().V1().DaemonSets().Informer()
+	daemonSets.AddEventHandler(eventHandler(c, func(obj interface{}) []string {
+		o, ok := obj.(metav1.Object)
+		if !ok {
+			return nil
+		}
+		if owner := metav1.GetControllerOf(o); owner != nil && owner.Kind == PerformanceProfileGVK.Kind {
+			return []string{owner.Name}
+		}
+		return nil
+	}, generationChanged))
+
+	go profiles.Run(ctx.Done())
+	go daemonSets.Run(ctx.Done())
+	c.synced = append(c.synced, profiles.HasSynced, daemonSets.HasSynced)
+	return c
+}
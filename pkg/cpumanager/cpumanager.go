@@ -0,0 +1,112 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package cpumanager checks that the shared cpus are not handed out exclusively by the kubelet CPU Manager
+package cpumanager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+)
+
+var (
+	// StatePath is the kubelet CPU Manager checkpoint file
+	StatePath = "/var/lib/kubelet/cpu_manager_state"
+	// OnlinePath lists the node's online cpus
+	OnlinePath = "/sys/devices/system/cpu/online"
+)
+
+// State is the subset of the kubelet CPU Manager checkpoint the plugin uses
+type State struct {
+	PolicyName    string `json:"policyName"`
+	DefaultCPUSet string `json:"defaultCpuSet"`
+	// Entries maps pod uids and container names to their exclusive cpus
+	Entries map[string]map[string]string `json:"entries,omitempty"`
+}
+
+// ReadState reads the CPU Manager checkpoint.
+// It returns nil without an error when the file does not exist.
+// The file is opened on every read, since kubelet replaces it by renaming a new file over it.
+func ReadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CPU Manager state %q: %w", path, err)
+	}
+	st := &State{}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to decode CPU Manager state %q: %w", path, err)
+	}
+	return st, nil
+}
+
+// ReadOnlineCPUs reads the node's online cpus
+func ReadOnlineCPUs(path string) (cpuset.CPUSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cpuset.CPUSet{}, fmt.Errorf("failed to read online cpus: %w", err)
+	}
+	cpus, err := cpuset.Parse(strings.TrimSpace(string(data)))
+	if err != nil {
+		return cpuset.CPUSet{}, fmt.Errorf("failed to parse online cpus: %w", err)
+	}
+	return cpus, nil
+}
+
+// Check returns an error when some of the shared cpus are offline,
+// or allocated exclusively to containers by the CPU Manager.
+func Check(shared cpuset.CPUSet, statePath, onlinePath string) error {
+	online, err := ReadOnlineCPUs(onlinePath)
+	if err != nil {
+		return err
+	}
+	if offline := shared.Difference(online); !offline.IsEmpty() {
+		return fmt.Errorf("shared cpus %q are not online; online cpus are %q", offline.String(), online.String())
+	}
+
+	st, err := ReadState(statePath)
+	if err != nil || st == nil {
+		return err
+	}
+	var conflicts []string
+	var overlap cpuset.CPUSet
+	for podUID, containers := range st.Entries {
+		for name, cpus := range containers {
+			exclusive, err := cpuset.Parse(cpus)
+			if err != nil {
+				return fmt.Errorf("failed to parse pod %q container %q exclusive cpus %q: %w", podUID, name, cpus, err)
+			}
+			if common := exclusive.Intersection(shared); !common.IsEmpty() {
+				overlap = overlap.Union(common)
+				conflicts = append(conflicts, fmt.Sprintf("pod %s container %s (%s)", podUID, name, common.String()))
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("shared cpus %q are allocated exclusively by the CPU Manager to: %s",
+			overlap.String(), strings.Join(conflicts, ", "))
+	}
+	return nil
+}
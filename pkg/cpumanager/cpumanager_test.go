@@ -0,0 +1,185 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cpumanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/health"
+)
+
+const fakeState = `{"policyName":"static","defaultCpuSet":"0-1,6-7","entries":{"uid-1":{"app":"2-3"},"uid-2":{"app":"4-5"}},"checksum":1234}`
+
+func writeFiles(t *testing.T, state, online string) (string, string) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "cpu_manager_state")
+	if state != "" {
+		if err := os.WriteFile(statePath, []byte(state), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	onlinePath := filepath.Join(dir, "online")
+	if err := os.WriteFile(onlinePath, []byte(online+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return statePath, onlinePath
+}
+
+func TestCheck(t *testing.T) {
+	testCases := []struct {
+		name    string
+		shared  cpuset.CPUSet
+		state   string
+		online  string
+		wantErr string
+	}{
+		{
+			name:   "no overlap",
+			shared: cpuset.New(0, 1),
+			state:  fakeState,
+			online: "0-7",
+		},
+		{
+			name:   "no CPU Manager state",
+			shared: cpuset.New(2),
+			online: "0-7",
+		},
+		{
+			name:   "none policy",
+			shared: cpuset.New(2),
+			state:  `{"policyName":"none","defaultCpuSet":"","checksum":1}`,
+			online: "0-7",
+		},
+		{
+			name:    "overlap with exclusive cpus",
+			shared:  cpuset.New(1, 2, 5),
+			state:   fakeState,
+			online:  "0-7",
+			wantErr: `shared cpus "2,5" are allocated exclusively by the CPU Manager to: pod uid-1 container app (2), pod uid-2 container app (5)`,
+		},
+		{
+			name:    "offline cpus",
+			shared:  cpuset.New(0, 8),
+			state:   fakeState,
+			online:  "0-7",
+			wantErr: `shared cpus "8" are not online`,
+		},
+		{
+			name:    "corrupted state",
+			shared:  cpuset.New(0),
+			state:   "{",
+			online:  "0-7",
+			wantErr: "failed to decode CPU Manager state",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			statePath, onlinePath := writeFiles(t, tc.state, tc.online)
+			err := Check(tc.shared, statePath, onlinePath)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+type fakeRecorder struct {
+	messages []string
+}
+
+func (f *fakeRecorder) Warning(reason, message string) {
+	f.messages = append(f.messages, reason+": "+message)
+}
+
+func ready(t *testing.T) bool {
+	rec := httptest.NewRecorder()
+	health.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	return rec.Code == http.StatusOK
+}
+
+func TestValidator(t *testing.T) {
+	statePath, onlinePath := writeFiles(t, fakeState, "0-7")
+	shared := cpuset.New(0, 1)
+	recorder := &fakeRecorder{}
+	v := NewValidator(func() cpuset.CPUSet { return shared }, recorder)
+	v.statePath, v.onlinePath = statePath, onlinePath
+
+	if err := v.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !ready(t) {
+		t.Fatalf("expected the plugin to be ready")
+	}
+
+	// the same conflict is reported once
+	shared = cpuset.New(0, 2)
+	for i := 0; i < 2; i++ {
+		if err := v.Validate(); err == nil {
+			t.Fatalf("expected a conflict")
+		}
+	}
+	if ready(t) {
+		t.Fatalf("expected the plugin not to be ready")
+	}
+	if len(recorder.messages) != 1 || !strings.HasPrefix(recorder.messages[0], ReasonSharedCPUsConflict) {
+		t.Fatalf("unexpected events: %v", recorder.messages)
+	}
+
+	shared = cpuset.New(0)
+	if err := v.OnSharedCPUsChange(shared); err != nil {
+		t.Fatal(err)
+	}
+	if !ready(t) {
+		t.Fatalf("expected the plugin to be ready once the conflict is resolved")
+	}
+}
+
+func TestValidatorReplacedState(t *testing.T) {
+	statePath, onlinePath := writeFiles(t, fakeState, "0-7")
+	v := NewValidator(func() cpuset.CPUSet { return cpuset.New(6, 7) }, nil)
+	v.statePath, v.onlinePath = statePath, onlinePath
+	if err := v.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// kubelet writes a new checkpoint and renames it over the old one
+	tmp := filepath.Join(filepath.Dir(statePath), ".cpu_manager_state.tmp")
+	state := `{"policyName":"static","defaultCpuSet":"0-1","entries":{"uid-3":{"app":"6-7"}},"checksum":5678}`
+	if err := os.WriteFile(tmp, []byte(state), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, statePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Validate(); err == nil {
+		t.Fatalf("expected a conflict with the replaced checkpoint")
+	}
+}
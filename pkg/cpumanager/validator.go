@@ -0,0 +1,108 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cpumanager
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+
+	"github.com/golang/glog"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/events"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/health"
+)
+
+const (
+	// HealthComponent is the readiness component of the shared cpus validation
+	HealthComponent = "cpumanager"
+	// ReasonSharedCPUsConflict is the reason of the events about invalid shared cpus
+	ReasonSharedCPUsConflict = "SharedCPUsConflict"
+
+	checkPeriod = 30 * time.Second
+)
+
+// Validator checks the shared cpus against the CPU Manager state and the online cpus.
+// The plugin is not ready while they conflict, and an event is emitted on every new conflict.
+type Validator struct {
+	statePath  string
+	onlinePath string
+	sharedCPUs func() cpuset.CPUSet
+	// recorder emits the events, when set
+	recorder events.Recorder
+
+	mu sync.Mutex
+	// lastErr is the last conflict, so the same conflict is reported once
+	lastErr string
+}
+
+func NewValidator(sharedCPUs func() cpuset.CPUSet, recorder events.Recorder) *Validator {
+	health.Register(HealthComponent)
+	return &Validator{
+		statePath:  StatePath,
+		onlinePath: OnlinePath,
+		sharedCPUs: sharedCPUs,
+		recorder:   recorder,
+	}
+}
+
+// Validate checks the current shared cpus and updates the readiness accordingly
+func (v *Validator) Validate() error {
+	err := Check(v.sharedCPUs(), v.statePath, v.onlinePath)
+	health.SetReady(HealthComponent, err == nil)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err == nil {
+		if v.lastErr != "" {
+			glog.Infof("shared cpus conflict is resolved")
+		}
+		v.lastErr = ""
+		return nil
+	}
+	if err.Error() != v.lastErr {
+		glog.Errorf("invalid shared cpus: %v", err)
+		if v.recorder != nil {
+			v.recorder.Warning(ReasonSharedCPUsConflict, err.Error())
+		}
+	}
+	v.lastErr = err.Error()
+	return err
+}
+
+// Run validates the shared cpus periodically until the context is done
+func (v *Validator) Run(ctx context.Context) error {
+	ticker := time.NewTicker(checkPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			_ = v.Validate()
+		}
+	}
+}
+
+// OnSharedCPUsChange validates the shared cpus once they change.
+// It does not fail the change, since the validation only affects the readiness.
+func (v *Validator) OnSharedCPUsChange(cpuset.CPUSet) error {
+	_ = v.Validate()
+	return nil
+}
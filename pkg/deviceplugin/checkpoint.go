@@ -0,0 +1,98 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package deviceplugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// KubeletCheckpointPath is where kubelet keeps the devices it allocated to containers
+var KubeletCheckpointPath = filepath.Join(pluginapi.DevicePluginPath, "kubelet_internal_checkpoint")
+
+// kubeletCheckpoint holds the parts of the kubelet device manager checkpoint we care about
+type kubeletCheckpoint struct {
+	Data struct {
+		PodDeviceEntries []podDevicesEntry
+	}
+}
+
+type podDevicesEntry struct {
+	PodUID        string
+	ContainerName string
+	ResourceName  string
+	// DeviceIDs is a map of NUMA node to device ids,
+	// or a plain list of device ids in older kubelet versions
+	DeviceIDs json.RawMessage
+}
+
+// readAllocatedDevices returns the ids of the resource's devices
+// that are allocated to containers according to the kubelet checkpoint.
+// A missing checkpoint means nothing is allocated.
+func readAllocatedDevices(path, resourceName string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read kubelet checkpoint %q: %w", path, err)
+	}
+
+	cp := kubeletCheckpoint{}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("failed to parse kubelet checkpoint %q: %w", path, err)
+	}
+
+	var ids []string
+	for _, entry := range cp.Data.PodDeviceEntries {
+		if entry.ResourceName != resourceName {
+			continue
+		}
+		entryIDs, err := parseDeviceIDs(entry.DeviceIDs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse pod %q container %q devices: %w", entry.PodUID, entry.ContainerName, err)
+		}
+		ids = append(ids, entryIDs...)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func parseDeviceIDs(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	perNode := map[string][]string{}
+	if err := json.Unmarshal(raw, &perNode); err == nil {
+		var ids []string
+		for _, nodeIDs := range perNode {
+			ids = append(ids, nodeIDs...)
+		}
+		return ids, nil
+	}
+	var ids []string
+	if err := json.Unmarshal(raw, &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
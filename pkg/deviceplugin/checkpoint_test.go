@@ -0,0 +1,108 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package deviceplugin
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const fakeCheckpoint = `{
+  "Data": {
+    "PodDeviceEntries": [
+      {"PodUID": "pod1", "ContainerName": "ctr1", "ResourceName": "openshift.io/mutualcpu", "DeviceIDs": {"-1": ["3"]}, "AllocResp": ""},
+      {"PodUID": "pod2", "ContainerName": "ctr1", "ResourceName": "openshift.io/mutualcpu", "DeviceIDs": {"0": ["17"]}, "AllocResp": ""},
+      {"PodUID": "pod2", "ContainerName": "ctr2", "ResourceName": "openshift.io/mutualcpu-io", "DeviceIDs": {"0": ["1"]}, "AllocResp": ""},
+      {"PodUID": "pod3", "ContainerName": "ctr1", "ResourceName": "vendor.com/nic", "DeviceIDs": {"0": ["a", "b"]}, "AllocResp": ""}
+    ],
+    "RegisteredDevices": {"openshift.io/mutualcpu": ["0", "1", "2"]}
+  },
+  "Checksum": 1234
+}`
+
+const fakeLegacyCheckpoint = `{
+  "Data": {
+    "PodDeviceEntries": [
+      {"PodUID": "pod1", "ContainerName": "ctr1", "ResourceName": "openshift.io/mutualcpu", "DeviceIDs": ["5", "2"], "AllocResp": ""}
+    ]
+  },
+  "Checksum": 1234
+}`
+
+func TestReadAllocatedDevices(t *testing.T) {
+	testCases := []struct {
+		name         string
+		content      string
+		resourceName string
+		want         []string
+		isError      bool
+	}{
+		{
+			name:         "mutual cpus devices",
+			content:      fakeCheckpoint,
+			resourceName: MutualCPUDeviceName,
+			want:         []string{"17", "3"},
+		},
+		{
+			name:         "pool devices",
+			content:      fakeCheckpoint,
+			resourceName: Pool{Name: "io"}.DeviceName(),
+			want:         []string{"1"},
+		},
+		{
+			name:         "legacy format",
+			content:      fakeLegacyCheckpoint,
+			resourceName: MutualCPUDeviceName,
+			want:         []string{"2", "5"},
+		},
+		{
+			name:         "corrupted checkpoint",
+			content:      "{",
+			resourceName: MutualCPUDeviceName,
+			isError:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "kubelet_internal_checkpoint")
+			if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readAllocatedDevices(path, tc.resourceName)
+			if tc.isError {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("unexpected devices; want: %v got: %v", tc.want, got)
+			}
+		})
+	}
+
+	got, err := readAllocatedDevices(filepath.Join(t.TempDir(), "missing"), MutualCPUDeviceName)
+	if err != nil || len(got) != 0 {
+		t.Fatalf("expected no devices and no error for a missing checkpoint; got: %v, %v", got, err)
+	}
+}
@@ -17,23 +17,106 @@
 package deviceplugin
 
 import (
+	"context"
+	"strings"
+	"sync"
+
 	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
 
 	"github.com/containerd/nri/pkg/api"
 	"github.com/containers/podman/v4/pkg/env"
 	"github.com/golang/glog"
 	"github.com/kubevirt/device-plugin-manager/pkg/dpm"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/health"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/numa"
 )
 
 const (
+	DeviceIDsEnvVarName = "OPENSHIFT_MUTUALCPU_DEVICES"
+)
+
+// the resource names and the environment variable can be changed
+// with SetResourceNames, before the device plugin starts.
+var (
 	MutualCPUResourceNamespace = "openshift.io"
 	MutualCPUResourceName      = "mutualcpu"
 	MutualCPUDeviceName        = MutualCPUResourceNamespace + "/" + MutualCPUResourceName
 	EnvVarName                 = "OPENSHIFT_MUTUAL_CPUS"
 )
 
+// SetResourceNames changes the names under which the shared cpus are exposed
+func SetResourceNames(namespace, name, envVarName string) {
+	MutualCPUResourceNamespace = namespace
+	MutualCPUResourceName = name
+	MutualCPUDeviceName = namespace + "/" + name
+	EnvVarName = envVarName
+}
+
+// Options configure the device plugin
+type Options struct {
+	// MutualCPUs are served as the mutualcpu resource
+	MutualCPUs string
+	// Pools are served as additional resources, each in the format of <name>:<cpus>
+	Pools []string
+	// Topology attaches the NUMA nodes of the shared cpus to the devices, when set
+	Topology numa.Topology
+	// Headroom is the number of free devices advertised on top of the allocated ones
+	Headroom int
+	// Limit is the maximum number of devices advertised per resource
+	Limit int
+}
+
+// AllocationLister reports the devices of a resource that are assigned to containers
+type AllocationLister interface {
+	AllocatedDevices(ctx context.Context, resourceName string) ([]string, error)
+}
+
 type MutualCpu struct {
+	mu   sync.RWMutex
 	cpus cpuset.CPUSet
+	// pools are the named shared pools, each served as its own resource
+	pools []Pool
+	// topology is used for attaching NUMA nodes to the devices, when set
+	topology numa.Topology
+	// headroom is the number of free devices each resource advertises
+	headroom int
+	// limit is the maximum number of devices each resource advertises
+	limit int
+	// plugins maps resource names to the plugins serving them
+	plugins map[string]*pluginImp
+	// lister reports the allocated devices, when set
+	lister AllocationLister
+}
+
+// CPUs returns the current mutual cpus
+func (mc *MutualCpu) CPUs() cpuset.CPUSet {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	return mc.cpus
+}
+
+// SetCPUs replaces the mutual cpus handed to containers on future allocations
+func (mc *MutualCpu) SetCPUs(cpus cpuset.CPUSet) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	glog.Infof("%q cpus set to %q", MutualCPUDeviceName, cpus.String())
+	mc.cpus = cpus
+	return nil
+}
+
+// SetAllocationLister makes the plugins take the allocated devices from the lister,
+// so devices of pods that are gone are reclaimed even if their removal was missed.
+func (mc *MutualCpu) SetAllocationLister(l AllocationLister) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.lister = l
+}
+
+func (mc *MutualCpu) allocationLister() AllocationLister {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	return mc.lister
 }
 
 func (mc *MutualCpu) GetResourceNamespace() string {
@@ -41,27 +124,100 @@ func (mc *MutualCpu) GetResourceNamespace() string {
 }
 
 func (mc *MutualCpu) Discover(pnl chan dpm.PluginNameList) {
-	pnl <- []string{MutualCPUResourceName}
+	pnl <- mc.resourceNames()
+}
+
+// resourceNames returns the names of the resources that are served
+func (mc *MutualCpu) resourceNames() []string {
+	var names []string
+	if !mc.CPUs().IsEmpty() {
+		names = append(names, MutualCPUResourceName)
+	}
+	for _, p := range mc.pools {
+		names = append(names, p.ResourceName())
+	}
+	return names
 }
 
 func (mc *MutualCpu) NewPlugin(s string) dpm.PluginInterface {
-	return pluginImp{
-		mutualCpus: &mc.cpus,
-		update:     make(chan message),
+	var nodes []int
+	if mc.topology != nil {
+		nodes = mc.topology.NodesOf(mc.poolFor(s).CPUs)
+		glog.Infof("%q devices are spread over NUMA nodes %v", MutualCPUResourceNamespace+"/"+s, nodes)
+	}
+	p := newPluginImp(mc, s, mc.headroom, nodes)
+	p.limit = mc.limit
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.plugins == nil {
+		mc.plugins = make(map[string]*pluginImp)
 	}
+	mc.plugins[s] = p
+	return p
 }
 
-func New(cpus string) (*dpm.Manager, error) {
-	mutualCpus, err := cpuset.Parse(cpus)
+// Release returns the devices of the resource to the pool of free devices,
+// once the pod they were allocated to is gone.
+func (mc *MutualCpu) Release(resourceName string, ids []string) {
+	mc.mu.RLock()
+	p, ok := mc.plugins[resourceName]
+	mc.mu.RUnlock()
+	if !ok {
+		glog.Warningf("can not release devices %v of unknown resource %q", ids, resourceName)
+		return
+	}
+	p.release(ids)
+}
+
+// poolFor returns the pool that is served under the resource name
+func (mc *MutualCpu) poolFor(resourceName string) Pool {
+	for _, p := range mc.pools {
+		if p.ResourceName() == resourceName {
+			return p
+		}
+	}
+	return Pool{CPUs: mc.CPUs()}
+}
+
+// New returns the device plugin manager along with
+// the MutualCpu it serves, so the cpus can be updated later.
+func New(opts Options) (*dpm.Manager, *MutualCpu, error) {
+	mutualCpus, err := cpuset.Parse(opts.MutualCPUs)
+	if err != nil {
+		return nil, nil, err
+	}
+	sharedPools, err := ParsePools(opts.Pools)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if opts.Headroom <= 0 {
+		opts.Headroom = initialDevicesQuantity
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = devicesLimit
 	}
-	mc := &MutualCpu{cpus: mutualCpus}
-	return dpm.NewManager(mc), nil
+	mc := &MutualCpu{
+		cpus:     mutualCpus,
+		pools:    sharedPools,
+		topology: opts.Topology,
+		headroom: opts.Headroom,
+		limit:    opts.Limit,
+	}
+	// the plugin is ready once kubelet is connected to all of the resources
+	for _, name := range mc.resourceNames() {
+		health.Register(healthComponent(name))
+	}
+	return dpm.NewManager(mc), mc, nil
+}
+
+// healthComponent is the readiness component of the resource's registration with kubelet
+func healthComponent(resourceName string) string {
+	return "deviceplugin/" + resourceName
 }
 
-// Requested checks whether a given container is requesting the device
-func Requested(ctr *api.Container) bool {
+// RequestedPool checks whether a given container is requesting
+// the pool whose cpus are exposed by the environment variable
+func RequestedPool(ctr *api.Container, envVarName string) bool {
 	if ctr.Env == nil {
 		return false
 	}
@@ -73,10 +229,24 @@ func Requested(ctr *api.Container) bool {
 	}
 
 	for k, v := range envs {
-		if k == EnvVarName {
+		if k == envVarName {
 			glog.V(4).Infof("shared CPUs ids: %q allocated for container: %q", v, ctr.Name)
 			return true
 		}
 	}
 	return false
 }
+
+// DeviceIDs returns the ids of the pool's devices that were allocated to the container
+func DeviceIDs(ctr *api.Container, pool Pool) []string {
+	envs, err := env.ParseSlice(ctr.Env)
+	if err != nil {
+		glog.Errorf("failed to parse environment variables for container: %q; err: %v", ctr.Name, err)
+		return nil
+	}
+	v, ok := envs[pool.DeviceIDsEnvVarName()]
+	if !ok || v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
@@ -18,103 +18,406 @@ package deviceplugin
 
 import (
 	"context"
+	"fmt"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
-	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
 	"github.com/golang/glog"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/health"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/metrics"
 )
 
 const (
+	// initialDevicesQuantity is the default number of free devices
+	// that are advertised on top of the allocated ones
 	initialDevicesQuantity = 15
 	// the maximum pods per node are 256,
-	// so this number should be more than enough
+	// so this number should be more than enough by default
 	devicesLimit = 1024
+	// resyncPeriod is how often the allocated devices are compared with the ones kubelet reports
+	resyncPeriod = time.Minute
 )
 
-type message struct {
-	requestedDevices int
+type pluginImp struct {
+	mutualCpus   *MutualCpu
+	resourceName string
+	// headroom is the number of free devices to advertise
+	headroom int
+	// limit is the maximum number of devices to advertise
+	limit int
+	// nodes are the NUMA nodes of the shared cpus.
+	// devices are spread evenly among them.
+	nodes []int
+	// checkpointPath is the kubelet checkpoint to restore the allocated devices from
+	checkpointPath string
+
+	// mu protects allocated and stale
+	mu        sync.Mutex
+	allocated map[string]bool
+	// stale are allocated devices that kubelet did not report on the last resync
+	stale map[string]bool
+	// changed notifies ListAndWatch that the allocated devices have changed
+	changed chan struct{}
 }
 
-type pluginImp struct {
-	mutualCpus       *cpuset.CPUSet
-	update           chan message
-	allocatedDevices int
+func newPluginImp(mc *MutualCpu, resourceName string, headroom int, nodes []int) *pluginImp {
+	return &pluginImp{
+		mutualCpus:     mc,
+		resourceName:   resourceName,
+		headroom:       headroom,
+		limit:          devicesLimit,
+		nodes:          nodes,
+		checkpointPath: KubeletCheckpointPath,
+		allocated:      make(map[string]bool),
+		stale:          make(map[string]bool),
+		changed:        make(chan struct{}, 1),
+	}
 }
 
-func (p pluginImp) ListAndWatch(empty *pluginapi.Empty, server pluginapi.DevicePlugin_ListAndWatchServer) error {
-	var devID int
-	devs := makeDevices(initialDevicesQuantity, devID)
-	devID += len(devs)
+func (p *pluginImp) ListAndWatch(empty *pluginapi.Empty, server pluginapi.DevicePlugin_ListAndWatchServer) error {
+	// kubelet calls ListAndWatch once the plugin is registered
+	health.SetReady(healthComponent(p.resourceName), true)
+	defer health.SetReady(healthComponent(p.resourceName), false)
+
+	// restore the devices that are already allocated,
+	// so a restart would not take them away from running containers.
+	allocated, err := p.listAllocated(server.Context())
+	if err != nil {
+		glog.Warningf("failed to list allocated devices, restoring them from the kubelet checkpoint: %v", err)
+		if allocated, err = readAllocatedDevices(p.checkpointPath, p.deviceName()); err != nil {
+			glog.Warningf("failed to restore allocated devices: %v", err)
+		}
+	}
+	p.allocate(allocated)
+	glog.V(2).Infof("%d %q devices are already allocated", len(allocated), p.resourceName)
 
-	resp := &pluginapi.ListAndWatchResponse{Devices: devs}
-	glog.V(4).Infof("ListAndWatch respond with: %+v", resp)
-	if err := server.Send(resp); err != nil {
-		return err
+	var resync <-chan time.Time
+	if p.mutualCpus.allocationLister() != nil {
+		ticker := time.NewTicker(resyncPeriod)
+		defer ticker.Stop()
+		resync = ticker.C
 	}
+
+	var sent []*pluginapi.Device
 	// never return, keep the connection open
 	for {
-		u := <-p.update
-		p.allocatedDevices += u.requestedDevices
-		if p.allocatedDevices > devicesLimit {
-			glog.V(2).Infof("Warning: device limit has reached. can not populate more %q makeDevices", MutualCPUDeviceName)
-			continue
+		devs := p.devices()
+		if !sameDevices(devs, sent) {
+			resp := &pluginapi.ListAndWatchResponse{Devices: devs}
+			glog.V(4).Infof("ListAndWatch respond with: %+v", resp)
+			if err := server.Send(resp); err != nil {
+				return err
+			}
+			sent = devs
 		}
-		// check if more makeDevices are needed
-		if p.allocatedDevices >= len(devs) {
-			newDevs := makeDevices(initialDevicesQuantity, devID)
-			devID += len(newDevs)
-			devs = append(devs, newDevs...)
-
-			resp = &pluginapi.ListAndWatchResponse{Devices: devs}
-			err := server.Send(resp)
-			glog.V(4).Infof("ListAndWatch update respond with: %+v", resp)
+		select {
+		case <-p.changed:
+		case <-resync:
+			ids, err := p.listAllocated(server.Context())
 			if err != nil {
-				return err
+				glog.Warningf("failed to resync allocated devices: %v", err)
+				continue
 			}
+			p.resync(ids)
 		}
 	}
 }
 
-func (p pluginImp) Allocate(ctx context.Context, request *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
-	response := &pluginapi.AllocateResponse{}
+// listAllocated returns the allocated devices as reported by kubelet
+func (p *pluginImp) listAllocated(ctx context.Context) ([]string, error) {
+	l := p.mutualCpus.allocationLister()
+	if l == nil {
+		return nil, fmt.Errorf("no allocation lister for %q", p.resourceName)
+	}
+	return l.AllocatedDevices(ctx, p.deviceName())
+}
+
+func (p *pluginImp) deviceName() string {
+	return MutualCPUResourceNamespace + "/" + p.resourceName
+}
 
-	p.update <- message{requestedDevices: len(request.ContainerRequests)}
+func (p *pluginImp) Allocate(ctx context.Context, request *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
+	defer health.Begin("Allocate")()
+	response := &pluginapi.AllocateResponse{}
 
 	glog.V(4).Infof("Allocate called with %+v", request)
-	for range request.ContainerRequests {
+	pool := p.mutualCpus.poolFor(p.resourceName)
+	for _, cr := range request.ContainerRequests {
+		p.allocate(cr.DevicesIDs)
 		containerResponse := &pluginapi.ContainerAllocateResponse{
-			Envs: map[string]string{"OPENSHIFT_MUTUAL_CPUS": p.mutualCpus.String()},
+			Envs: map[string]string{
+				pool.EnvVarName():          pool.CPUs.String(),
+				pool.DeviceIDsEnvVarName(): strings.Join(cr.DevicesIDs, ","),
+			},
 		}
 		response.ContainerResponses = append(response.ContainerResponses, containerResponse)
 	}
 	return response, nil
 }
 
-func (p pluginImp) GetDevicePluginOptions(ctx context.Context, empty *pluginapi.Empty) (*pluginapi.DevicePluginOptions, error) {
-	return &pluginapi.DevicePluginOptions{}, nil
+// Stop is called by the plugins manager once the plugin is unregistered from kubelet
+func (p *pluginImp) Stop() error {
+	health.SetReady(healthComponent(p.resourceName), false)
+	return nil
 }
 
-func (p pluginImp) GetPreferredAllocation(ctx context.Context, request *pluginapi.PreferredAllocationRequest) (*pluginapi.PreferredAllocationResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method PreStartContainer not implemented")
+func (p *pluginImp) GetDevicePluginOptions(ctx context.Context, empty *pluginapi.Empty) (*pluginapi.DevicePluginOptions, error) {
+	return &pluginapi.DevicePluginOptions{
+		GetPreferredAllocationAvailable: true,
+	}, nil
+}
+
+// GetPreferredAllocation prefers devices from a single NUMA node,
+// so the devices would be aligned with the container's exclusive cpus
+func (p *pluginImp) GetPreferredAllocation(ctx context.Context, request *pluginapi.PreferredAllocationRequest) (*pluginapi.PreferredAllocationResponse, error) {
+	response := &pluginapi.PreferredAllocationResponse{}
+	glog.V(4).Infof("GetPreferredAllocation called with %+v", request)
+	for _, cr := range request.ContainerRequests {
+		response.ContainerResponses = append(response.ContainerResponses, &pluginapi.ContainerPreferredAllocationResponse{
+			DeviceIDs: p.preferredDevices(cr.AvailableDeviceIDs, cr.MustIncludeDeviceIDs, int(cr.AllocationSize)),
+		})
+	}
+	return response, nil
 }
 
-func (p pluginImp) PreStartContainer(ctx context.Context, request *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
+func (p *pluginImp) PreStartContainer(ctx context.Context, request *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method PreStartContainer not implemented")
 }
 
-func makeDevices(count, devID int) []*pluginapi.Device {
+// preferredDevices returns the must include devices, completed up to
+// the allocation size with available devices from as few NUMA nodes as possible
+func (p *pluginImp) preferredDevices(available, mustInclude []string, size int) []string {
+	res := append([]string{}, mustInclude...)
+	included := make(map[string]bool)
+	for _, id := range mustInclude {
+		included[id] = true
+	}
+
+	byNode := make(map[int][]string)
+	var unknown []string
+	for _, id := range available {
+		if included[id] {
+			continue
+		}
+		if node, ok := p.nodeOf(id); ok {
+			byNode[node] = append(byNode[node], id)
+		} else {
+			unknown = append(unknown, id)
+		}
+	}
+
+	// prefer the node of the must include devices,
+	// otherwise the node with the most available devices
+	preferred := -1
+	for _, id := range mustInclude {
+		if node, ok := p.nodeOf(id); ok {
+			preferred = node
+			break
+		}
+	}
+	nodes := make([]int, 0, len(byNode))
+	for node := range byNode {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if (nodes[i] == preferred) != (nodes[j] == preferred) {
+			return nodes[i] == preferred
+		}
+		if len(byNode[nodes[i]]) != len(byNode[nodes[j]]) {
+			return len(byNode[nodes[i]]) > len(byNode[nodes[j]])
+		}
+		return nodes[i] < nodes[j]
+	})
+
+	candidates := make([]string, 0, len(available))
+	for _, node := range nodes {
+		candidates = append(candidates, byNode[node]...)
+	}
+	candidates = append(candidates, unknown...)
+	for _, id := range candidates {
+		if len(res) >= size {
+			break
+		}
+		res = append(res, id)
+	}
+	return res
+}
+
+// nodeOf returns the NUMA node of the device
+func (p *pluginImp) nodeOf(devID string) (int, bool) {
+	if len(p.nodes) == 0 {
+		return 0, false
+	}
+	id, err := strconv.Atoi(devID)
+	if err != nil {
+		return 0, false
+	}
+	return p.nodes[id%len(p.nodes)], true
+}
+
+// allocate marks the devices as allocated
+func (p *pluginImp) allocate(ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	p.mu.Lock()
+	for _, id := range ids {
+		p.allocated[id] = true
+	}
+	p.mu.Unlock()
+	p.notify()
+}
+
+// release returns the devices to the pool of free devices
+func (p *pluginImp) release(ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	p.mu.Lock()
+	for _, id := range ids {
+		delete(p.allocated, id)
+		delete(p.stale, id)
+	}
+	p.mu.Unlock()
+	glog.V(2).Infof("released %q devices %v", p.resourceName, ids)
+	p.notify()
+}
+
+// resync adds the devices kubelet reports and releases the ones it doesn't.
+// A device is released only when it is missing twice in a row,
+// since kubelet may not have recorded a device that was just allocated.
+func (p *pluginImp) resync(ids []string) {
+	reported := make(map[string]bool)
+	for _, id := range ids {
+		reported[id] = true
+	}
+
+	p.mu.Lock()
+	var released []string
+	for id := range p.allocated {
+		if reported[id] {
+			delete(p.stale, id)
+			continue
+		}
+		if p.stale[id] {
+			delete(p.allocated, id)
+			delete(p.stale, id)
+			released = append(released, id)
+			continue
+		}
+		p.stale[id] = true
+	}
+	for id := range reported {
+		p.allocated[id] = true
+	}
+	p.mu.Unlock()
+
+	if len(released) > 0 {
+		sort.Strings(released)
+		glog.V(2).Infof("released %q devices %v that are no longer assigned", p.resourceName, released)
+	}
+	p.notify()
+}
+
+func (p *pluginImp) notify() {
+	select {
+	case p.changed <- struct{}{}:
+	default:
+		// ListAndWatch is already notified
+	}
+}
+
+// devices returns the allocated devices along with the headroom of free devices.
+// The free devices are spread evenly among the NUMA nodes.
+func (p *pluginImp) devices() []*pluginapi.Device {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	var devs []*pluginapi.Device
-	for i := 0; i < count; i++ {
-		dev := &pluginapi.Device{
-			ID:     strconv.Itoa(devID + i),
-			Health: pluginapi.Healthy,
+	for id := range p.allocated {
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			glog.Warningf("ignoring unknown %q device %q", p.resourceName, id)
+			continue
 		}
-		devs = append(devs, dev)
+		devs = append(devs, p.makeDevice(n))
 	}
+	if len(devs) >= p.limit {
+		glog.Warningf("device limit has reached. can not populate more %q devices", p.resourceName)
+	}
+
+	freePerNode := p.headroomPerNode()
+	free := 0
+	for id := 0; id < p.limit && len(devs) < p.limit && free < p.headroom; id++ {
+		if p.allocated[strconv.Itoa(id)] {
+			continue
+		}
+		node, _ := p.nodeOf(strconv.Itoa(id))
+		if freePerNode[node] == 0 {
+			continue
+		}
+		freePerNode[node]--
+		free++
+		devs = append(devs, p.makeDevice(id))
+	}
+
+	sort.Slice(devs, func(i, j int) bool {
+		a, _ := strconv.Atoi(devs[i].ID)
+		b, _ := strconv.Atoi(devs[j].ID)
+		return a < b
+	})
+	metrics.AllocatedDevices.WithLabelValues(p.resourceName).Set(float64(len(p.allocated)))
+	metrics.AdvertisedDevices.WithLabelValues(p.resourceName).Set(float64(len(devs)))
 	return devs
 }
+
+// headroomPerNode splits the headroom among the NUMA nodes; the first nodes
+// get one more free device each when the headroom does not divide evenly.
+func (p *pluginImp) headroomPerNode() map[int]int {
+	if len(p.nodes) == 0 {
+		return map[int]int{0: p.headroom}
+	}
+	perNode := make(map[int]int)
+	for i, node := range p.nodes {
+		perNode[node] = p.headroom / len(p.nodes)
+		if i < p.headroom%len(p.nodes) {
+			perNode[node]++
+		}
+	}
+	return perNode
+}
+
+func (p *pluginImp) makeDevice(id int) *pluginapi.Device {
+	dev := &pluginapi.Device{
+		ID:     strconv.Itoa(id),
+		Health: pluginapi.Healthy,
+	}
+	if node, ok := p.nodeOf(dev.ID); ok {
+		dev.Topology = &pluginapi.TopologyInfo{
+			Nodes: []*pluginapi.NUMANode{{ID: int64(node)}},
+		}
+	}
+	return dev
+}
+
+// sameDevices checks whether two sorted devices lists hold the same device ids
+func sameDevices(a, b []*pluginapi.Device) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID {
+			return false
+		}
+	}
+	return true
+}
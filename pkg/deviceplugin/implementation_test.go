@@ -0,0 +1,302 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package deviceplugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+)
+
+// fakeListAndWatchServer records the ListAndWatch responses
+type fakeListAndWatchServer struct {
+	grpc.ServerStream
+	resps chan *pluginapi.ListAndWatchResponse
+}
+
+func (f *fakeListAndWatchServer) Context() context.Context {
+	return context.Background()
+}
+
+func (f *fakeListAndWatchServer) Send(resp *pluginapi.ListAndWatchResponse) error {
+	f.resps <- resp
+	return nil
+}
+
+func TestMakeDevicesTopology(t *testing.T) {
+	p := &pluginImp{nodes: []int{0, 1}}
+	wantNodes := []int64{1, 0, 1, 0}
+	for i := range wantNodes {
+		dev := p.makeDevice(3 + i)
+		if dev.Topology == nil || len(dev.Topology.Nodes) != 1 {
+			t.Fatalf("device %q has no topology", dev.ID)
+		}
+		if got := dev.Topology.Nodes[0].ID; got != wantNodes[i] {
+			t.Errorf("device %q unexpected NUMA node; want: %d got: %d", dev.ID, wantNodes[i], got)
+		}
+	}
+
+	p = &pluginImp{}
+	for i := 0; i < 2; i++ {
+		if dev := p.makeDevice(i); dev.Topology != nil {
+			t.Errorf("device %q should not have topology", dev.ID)
+		}
+	}
+}
+
+func TestGetPreferredAllocation(t *testing.T) {
+	testCases := []struct {
+		name        string
+		nodes       []int
+		available   []string
+		mustInclude []string
+		size        int32
+		want        []string
+	}{
+		{
+			name:      "single node with most available devices",
+			nodes:     []int{0, 1},
+			available: []string{"0", "1", "3", "5"},
+			size:      2,
+			want:      []string{"1", "3"},
+		},
+		{
+			name:        "node of must include devices",
+			nodes:       []int{0, 1},
+			available:   []string{"0", "1", "2", "3", "5"},
+			mustInclude: []string{"2"},
+			size:        2,
+			want:        []string{"2", "0"},
+		},
+		{
+			name:      "spill over to another node",
+			nodes:     []int{0, 1},
+			available: []string{"0", "1", "3"},
+			size:      3,
+			want:      []string{"1", "3", "0"},
+		},
+		{
+			name:      "no topology",
+			available: []string{"4", "2", "7"},
+			size:      2,
+			want:      []string{"4", "2"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &pluginImp{nodes: tc.nodes}
+			resp, err := p.GetPreferredAllocation(context.Background(), &pluginapi.PreferredAllocationRequest{
+				ContainerRequests: []*pluginapi.ContainerPreferredAllocationRequest{
+					{
+						AvailableDeviceIDs:   tc.available,
+						MustIncludeDeviceIDs: tc.mustInclude,
+						AllocationSize:       tc.size,
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.ContainerResponses) != 1 {
+				t.Fatalf("expected a single container response, got: %d", len(resp.ContainerResponses))
+			}
+			if got := resp.ContainerResponses[0].DeviceIDs; !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("unexpected devices; want: %v got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestListAndWatchRestoresAllocatedDevices(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kubelet_internal_checkpoint")
+	if err := os.WriteFile(path, []byte(fakeCheckpoint), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newPluginImp(&MutualCpu{}, MutualCPUResourceName, initialDevicesQuantity, nil)
+	p.checkpointPath = path
+	server := &fakeListAndWatchServer{resps: make(chan *pluginapi.ListAndWatchResponse, 10)}
+	go func() {
+		_ = p.ListAndWatch(&pluginapi.Empty{}, server)
+	}()
+
+	resp := <-server.resps
+	// devices 3 and 17 are allocated, so they are advertised along with the free devices
+	want := []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12", "13", "14", "15", "17"}
+	if got := deviceIDs(resp.Devices); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected devices; want: %v got: %v", want, got)
+	}
+
+	// releasing the devices shrinks the list back to the headroom
+	p.release([]string{"3", "17"})
+	resp = <-server.resps
+	if len(resp.Devices) != initialDevicesQuantity {
+		t.Fatalf("unexpected number of devices; want: %d got: %d", initialDevicesQuantity, len(resp.Devices))
+	}
+}
+
+func TestListAndWatchFromAllocationLister(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kubelet_internal_checkpoint")
+	if err := os.WriteFile(path, []byte(fakeCheckpoint), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mc := &MutualCpu{}
+	mc.SetAllocationLister(&fakeAllocationLister{ids: map[string][]string{MutualCPUDeviceName: {"2"}}})
+	p := newPluginImp(mc, MutualCPUResourceName, 1, nil)
+	p.checkpointPath = path
+	server := &fakeListAndWatchServer{resps: make(chan *pluginapi.ListAndWatchResponse, 10)}
+	go func() {
+		_ = p.ListAndWatch(&pluginapi.Empty{}, server)
+	}()
+
+	// the lister takes precedence over the checkpoint
+	resp := <-server.resps
+	if got, want := deviceIDs(resp.Devices), []string{"0", "2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected devices; want: %v got: %v", want, got)
+	}
+}
+
+func TestResync(t *testing.T) {
+	p := newPluginImp(&MutualCpu{}, MutualCPUResourceName, 1, nil)
+	p.allocate([]string{"1", "2"})
+
+	// device 2 is missing for the first time, so it is kept
+	p.resync([]string{"1", "3"})
+	if got, want := deviceIDs(p.devices()), []string{"0", "1", "2", "3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected devices; want: %v got: %v", want, got)
+	}
+	// device 2 is missing again, so it is released
+	p.resync([]string{"1", "3"})
+	if got, want := deviceIDs(p.devices()), []string{"0", "1", "3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected devices; want: %v got: %v", want, got)
+	}
+}
+
+func TestDevices(t *testing.T) {
+	testCases := []struct {
+		name      string
+		nodes     []int
+		headroom  int
+		allocated []string
+		want      []string
+	}{
+		{
+			name:     "headroom only",
+			headroom: 3,
+			want:     []string{"0", "1", "2"},
+		},
+		{
+			name:      "allocated devices are kept",
+			headroom:  2,
+			allocated: []string{"1", "5"},
+			want:      []string{"0", "1", "2", "5"},
+		},
+		{
+			name:      "free devices are spread among NUMA nodes",
+			nodes:     []int{0, 1},
+			headroom:  4,
+			allocated: []string{"0", "2", "4"},
+			// 0, 2 and 4 are on node 0, so its free devices are 6 and 8
+			want: []string{"0", "1", "2", "3", "4", "6", "8"},
+		},
+		{
+			name:     "headroom that does not divide evenly among NUMA nodes",
+			nodes:    []int{0, 1},
+			headroom: 3,
+			// node 0 gets the extra free device
+			want: []string{"0", "1", "2"},
+		},
+		{
+			name:      "headroom that does not divide evenly among NUMA nodes with allocated devices",
+			nodes:     []int{0, 1, 2},
+			headroom:  5,
+			allocated: []string{"0"},
+			// nodes 0 and 1 get 2 free devices each, node 2 gets 1
+			want: []string{"0", "1", "2", "3", "4", "6"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newPluginImp(&MutualCpu{}, MutualCPUResourceName, tc.headroom, tc.nodes)
+			p.allocate(tc.allocated)
+			if got := deviceIDs(p.devices()); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("unexpected devices; want: %v got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	mc := &MutualCpu{cpus: cpuset.New(0, 1)}
+	p := newPluginImp(mc, MutualCPUResourceName, 1, nil)
+	resp, err := p.Allocate(context.Background(), &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+			{DevicesIDs: []string{"0"}},
+			{DevicesIDs: []string{"1", "2"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEnvs := []map[string]string{
+		{EnvVarName: "0-1", DeviceIDsEnvVarName: "0"},
+		{EnvVarName: "0-1", DeviceIDsEnvVarName: "1,2"},
+	}
+	for i, cr := range resp.ContainerResponses {
+		if !reflect.DeepEqual(cr.Envs, wantEnvs[i]) {
+			t.Errorf("unexpected envs; want: %v got: %v", wantEnvs[i], cr.Envs)
+		}
+	}
+	// the allocated devices are advertised along with a free one
+	if got, want := deviceIDs(p.devices()), []string{"0", "1", "2", "3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected devices; want: %v got: %v", want, got)
+	}
+}
+
+// fakeAllocationLister reports fixed allocated devices per resource
+type fakeAllocationLister struct {
+	ids map[string][]string
+}
+
+func (f *fakeAllocationLister) AllocatedDevices(ctx context.Context, resourceName string) ([]string, error) {
+	return f.ids[resourceName], nil
+}
+
+func deviceIDs(devs []*pluginapi.Device) []string {
+	var ids []string
+	for _, dev := range devs {
+		ids = append(ids, dev.ID)
+	}
+	return ids
+}
+
+func TestGetDevicePluginOptions(t *testing.T) {
+	opts, err := (&pluginImp{}).GetDevicePluginOptions(context.Background(), &pluginapi.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.GetPreferredAllocationAvailable {
+		t.Fatalf("expected preferred allocation to be advertised")
+	}
+}
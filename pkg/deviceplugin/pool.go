@@ -0,0 +1,117 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package deviceplugin
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+)
+
+// Pool is a named set of shared cpus, exposed as a separate device resource.
+// Containers that request the pool's resource get the pool's cpus
+// in addition to their exclusive cpus.
+type Pool struct {
+	Name string
+	CPUs cpuset.CPUSet
+}
+
+// ResourceName returns the name of the device resource that represents the pool
+func (p Pool) ResourceName() string {
+	return PoolResourceName(p.Name)
+}
+
+// DeviceName returns the fully qualified name of the device resource that represents the pool
+func (p Pool) DeviceName() string {
+	return MutualCPUResourceNamespace + "/" + p.ResourceName()
+}
+
+// EnvVarName returns the name of the environment variable that holds the pool's cpus
+func (p Pool) EnvVarName() string {
+	return PoolEnvVarName(p.Name)
+}
+
+// DeviceIDsEnvVarName returns the name of the environment variable that holds
+// the ids of the pool's devices that were allocated to the container
+func (p Pool) DeviceIDsEnvVarName() string {
+	return PoolDeviceIDsEnvVarName(p.Name)
+}
+
+// PoolResourceName returns the name of the device resource for the pool name.
+// The unnamed pool is the one given by the mutual cpus.
+func PoolResourceName(name string) string {
+	if name == "" {
+		return MutualCPUResourceName
+	}
+	return MutualCPUResourceName + "-" + name
+}
+
+// PoolEnvVarName returns the name of the environment variable for the pool name.
+func PoolEnvVarName(name string) string {
+	if name == "" {
+		return EnvVarName
+	}
+	return EnvVarName + "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// PoolDeviceIDsEnvVarName returns the name of the environment variable for the pool's device ids.
+// It has a different prefix than the cpus variables, so it would not collide with any pool name.
+func PoolDeviceIDsEnvVarName(name string) string {
+	if name == "" {
+		return DeviceIDsEnvVarName
+	}
+	return DeviceIDsEnvVarName + "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// ParsePool parses a pool in the format of <name>:<cpus>, e.g. io:2-3
+func ParsePool(s string) (Pool, error) {
+	name, cpus, found := strings.Cut(s, ":")
+	if !found {
+		return Pool{}, fmt.Errorf("shared pool %q is not in the format of <name>:<cpus>", s)
+	}
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return Pool{}, fmt.Errorf("invalid shared pool name %q: %s", name, strings.Join(errs, "; "))
+	}
+	set, err := cpuset.Parse(cpus)
+	if err != nil {
+		return Pool{}, fmt.Errorf("failed to parse shared pool %q cpuset: %w", name, err)
+	}
+	if set.Size() == 0 {
+		return Pool{}, fmt.Errorf("shared pool %q has to have at least one CPU", name)
+	}
+	return Pool{Name: name, CPUs: set}, nil
+}
+
+// ParsePools parses a list of pools and verifies that the pool names are unique
+func ParsePools(pools []string) ([]Pool, error) {
+	var res []Pool
+	names := make(map[string]bool)
+	for _, s := range pools {
+		p, err := ParsePool(s)
+		if err != nil {
+			return nil, err
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("shared pool %q is defined more than once", p.Name)
+		}
+		names[p.Name] = true
+		res = append(res, p)
+	}
+	return res, nil
+}
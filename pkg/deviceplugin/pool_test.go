@@ -0,0 +1,116 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package deviceplugin
+
+import (
+	"testing"
+)
+
+func TestParsePools(t *testing.T) {
+	testCases := []struct {
+		name      string
+		pools     []string
+		wantNames []string
+		wantCPUs  []string
+		isError   bool
+	}{
+		{
+			name:      "multiple pools",
+			pools:     []string{"io:2-3", "mgmt:0"},
+			wantNames: []string{"io", "mgmt"},
+			wantCPUs:  []string{"2-3", "0"},
+		},
+		{
+			name:  "no pools",
+			pools: nil,
+		},
+		{
+			name:    "missing cpus",
+			pools:   []string{"io"},
+			isError: true,
+		},
+		{
+			name:    "empty cpus",
+			pools:   []string{"io:"},
+			isError: true,
+		},
+		{
+			name:    "bad cpus",
+			pools:   []string{"io:1,b"},
+			isError: true,
+		},
+		{
+			name:    "bad name",
+			pools:   []string{"IO_pool:1"},
+			isError: true,
+		},
+		{
+			name:    "duplicated name",
+			pools:   []string{"io:1", "io:2"},
+			isError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pools, err := ParsePools(tc.pools)
+			if tc.isError {
+				if err == nil {
+					t.Fatalf("expected error for pools %v", tc.pools)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pools) != len(tc.wantNames) {
+				t.Fatalf("unexpected number of pools; want: %d got: %d", len(tc.wantNames), len(pools))
+			}
+			for i, p := range pools {
+				if p.Name != tc.wantNames[i] {
+					t.Errorf("unexpected pool name; want: %q got: %q", tc.wantNames[i], p.Name)
+				}
+				if p.CPUs.String() != tc.wantCPUs[i] {
+					t.Errorf("unexpected pool cpus; want: %q got: %q", tc.wantCPUs[i], p.CPUs.String())
+				}
+			}
+		})
+	}
+}
+
+func TestPoolNames(t *testing.T) {
+	p := Pool{Name: "low-latency"}
+	if got := p.DeviceName(); got != "openshift.io/mutualcpu-low-latency" {
+		t.Errorf("unexpected device name %q", got)
+	}
+	if got := p.EnvVarName(); got != "OPENSHIFT_MUTUAL_CPUS_LOW_LATENCY" {
+		t.Errorf("unexpected env var name %q", got)
+	}
+	if got := p.DeviceIDsEnvVarName(); got != "OPENSHIFT_MUTUALCPU_DEVICES_LOW_LATENCY" {
+		t.Errorf("unexpected device ids env var name %q", got)
+	}
+	p = Pool{}
+	if got := p.DeviceName(); got != MutualCPUDeviceName {
+		t.Errorf("unexpected device name %q", got)
+	}
+	if got := p.EnvVarName(); got != EnvVarName {
+		t.Errorf("unexpected env var name %q", got)
+	}
+	if got := p.DeviceIDsEnvVarName(); got != DeviceIDsEnvVarName {
+		t.Errorf("unexpected device ids env var name %q", got)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package events
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// component is the source of the events
+	component = "mixedcpus-plugin"
+	// namespace of the events of cluster-scoped objects, such as nodes
+	namespace = metav1.NamespaceDefault
+)
+
+// Recorder emits Kubernetes events
+type Recorder interface {
+	Warning(reason, message string)
+}
+
+// NodeRecorder emits events about the node the plugin runs on
+type NodeRecorder struct {
+	cli      client.Client
+	nodeName string
+}
+
+func NewNodeRecorder(cli client.Client, nodeName string) *NodeRecorder {
+	return &NodeRecorder{
+		cli:      cli,
+		nodeName: nodeName,
+	}
+}
+
+// Warning emits a warning event about the node.
+// Failures are only logged, since events are best effort.
+func (r *NodeRecorder) Warning(reason, message string) {
+	now := metav1.NewTime(time.Now())
+	ev := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", r.nodeName, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Node",
+			Name:       r.nodeName,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           corev1.EventTypeWarning,
+		Source:         corev1.EventSource{Component: component, Host: r.nodeName},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if err := r.cli.Create(context.Background(), ev); err != nil {
+		glog.Errorf("failed to emit event %q about node %q: %v", reason, r.nodeName, err)
+	}
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package events
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNodeRecorderWarning(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	cli := fake.NewClientBuilder().WithScheme(scheme).Build()
+	NewNodeRecorder(cli, "worker-0").Warning("SharedCPUsConflict", "shared cpus conflict")
+
+	evs := &corev1.EventList{}
+	if err := cli.List(context.Background(), evs); err != nil {
+		t.Fatal(err)
+	}
+	if len(evs.Items) != 1 {
+		t.Fatalf("expected a single event, got: %d", len(evs.Items))
+	}
+	ev := evs.Items[0]
+	if ev.Namespace != namespace || ev.InvolvedObject.Kind != "Node" || ev.InvolvedObject.Name != "worker-0" {
+		t.Errorf("unexpected event object %s/%s %+v", ev.Namespace, ev.Name, ev.InvolvedObject)
+	}
+	if ev.Type != corev1.EventTypeWarning || ev.Reason != "SharedCPUsConflict" || ev.Message != "shared cpus conflict" {
+		t.Errorf("unexpected event %s %s %q", ev.Type, ev.Reason, ev.Message)
+	}
+}
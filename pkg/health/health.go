@@ -0,0 +1,157 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// NRI is the readiness component of the plugin's registration with the container runtime
+	NRI = "nri"
+	// stuckTimeout is how long an operation may run before the plugin is considered stuck
+	stuckTimeout = time.Minute
+)
+
+// Checker tracks the readiness of the plugin's components
+// and the operations that are in flight, for detecting a stuck event loop
+type Checker struct {
+	mu       sync.Mutex
+	ready    map[string]bool
+	inflight map[uint64]operation
+	nextID   uint64
+	timeout  time.Duration
+	now      func() time.Time
+}
+
+type operation struct {
+	name  string
+	start time.Time
+}
+
+// NewChecker returns a checker that fails liveness
+// when an operation runs longer than timeout
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{
+		ready:    make(map[string]bool),
+		inflight: make(map[uint64]operation),
+		timeout:  timeout,
+		now:      time.Now,
+	}
+}
+
+var defaultChecker = NewChecker(stuckTimeout)
+
+// Register adds a component that must be ready for the plugin to be ready
+func Register(component string) { defaultChecker.Register(component) }
+
+// SetReady marks the component as ready or not
+func SetReady(component string, ready bool) { defaultChecker.SetReady(component, ready) }
+
+// Begin tracks an operation until the returned function is called
+func Begin(name string) func() { return defaultChecker.Begin(name) }
+
+// Handler serves the liveness and readiness probes of the default checker
+func Handler() http.Handler { return defaultChecker.Handler() }
+
+// Serve exposes the probes on /healthz and /readyz, until the server fails
+func Serve(addr string) error {
+	return http.ListenAndServe(addr, Handler())
+}
+
+func (c *Checker) Register(component string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.ready[component]; !ok {
+		c.ready[component] = false
+	}
+}
+
+func (c *Checker) SetReady(component string, ready bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ready[component] = ready
+}
+
+func (c *Checker) Begin(name string) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.nextID
+	c.nextID++
+	c.inflight[id] = operation{name: name, start: c.now()}
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.inflight, id)
+	}
+}
+
+// Ready returns an error naming the components that are not ready
+func (c *Checker) Ready() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var notReady []string
+	for component, ready := range c.ready {
+		if !ready {
+			notReady = append(notReady, component)
+		}
+	}
+	if len(notReady) > 0 {
+		sort.Strings(notReady)
+		return fmt.Errorf("not ready: %s", strings.Join(notReady, ", "))
+	}
+	return nil
+}
+
+// Alive returns an error naming the operations that are stuck
+func (c *Checker) Alive() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var stuck []string
+	for _, op := range c.inflight {
+		if d := c.now().Sub(op.start); d > c.timeout {
+			stuck = append(stuck, fmt.Sprintf("%s (%s)", op.name, d.Round(time.Second)))
+		}
+	}
+	if len(stuck) > 0 {
+		sort.Strings(stuck)
+		return fmt.Errorf("stuck: %s", strings.Join(stuck, ", "))
+	}
+	return nil
+}
+
+func (c *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", probe(c.Alive))
+	mux.HandleFunc("/readyz", probe(c.Ready))
+	return mux
+}
+
+func probe(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
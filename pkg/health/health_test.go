@@ -0,0 +1,89 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReady(t *testing.T) {
+	c := NewChecker(time.Minute)
+	if err := c.Ready(); err != nil {
+		t.Fatalf("expected to be ready without components, got: %v", err)
+	}
+	c.Register(NRI)
+	c.Register("deviceplugin/mutualcpu")
+	if err := c.Ready(); err == nil {
+		t.Fatalf("expected not to be ready before the components are")
+	}
+	c.SetReady(NRI, true)
+	if err := c.Ready(); err == nil || err.Error() != "not ready: deviceplugin/mutualcpu" {
+		t.Fatalf("unexpected readiness error: %v", err)
+	}
+	c.SetReady("deviceplugin/mutualcpu", true)
+	if err := c.Ready(); err != nil {
+		t.Fatalf("expected to be ready, got: %v", err)
+	}
+	// registering again does not reset the readiness
+	c.Register(NRI)
+	if err := c.Ready(); err != nil {
+		t.Fatalf("expected to be ready, got: %v", err)
+	}
+}
+
+func TestAlive(t *testing.T) {
+	now := time.Now()
+	c := NewChecker(time.Minute)
+	c.now = func() time.Time { return now }
+
+	done := c.Begin("CreateContainer")
+	stuck := c.Begin("UpdateContainer")
+	if err := c.Alive(); err != nil {
+		t.Fatalf("expected to be alive, got: %v", err)
+	}
+	done()
+	now = now.Add(2 * time.Minute)
+	if err := c.Alive(); err == nil || err.Error() != "stuck: UpdateContainer (2m0s)" {
+		t.Fatalf("unexpected liveness error: %v", err)
+	}
+	stuck()
+	if err := c.Alive(); err != nil {
+		t.Fatalf("expected to be alive, got: %v", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	c := NewChecker(time.Minute)
+	c.Register(NRI)
+	testCases := []struct {
+		path string
+		want int
+	}{
+		{path: "/healthz", want: http.StatusOK},
+		{path: "/readyz", want: http.StatusServiceUnavailable},
+	}
+	for _, tc := range testCases {
+		rec := httptest.NewRecorder()
+		c.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.want {
+			t.Errorf("%s: unexpected status; want: %d got: %d", tc.path, tc.want, rec.Code)
+		}
+	}
+}
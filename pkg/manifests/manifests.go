@@ -26,6 +26,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -42,7 +43,16 @@ type Manifests struct {
 	DS   appsv1.DaemonSet
 	Role rbacv1.Role
 	RB   rbacv1.RoleBinding
-	SCC  securityv1.SecurityContextConstraints
+	// CR and CRB grant the plugin the access to the cluster-scoped objects,
+	// i.e. the events and the status of its node
+	CR  rbacv1.ClusterRole
+	CRB rbacv1.ClusterRoleBinding
+	SCC securityv1.SecurityContextConstraints
+	Svc corev1.Service
+	// SM is a prometheus-operator ServiceMonitor
+	SM unstructured.Unstructured
+	// serviceMonitor controls whether Svc and SM are deployed
+	serviceMonitor bool
 }
 
 func Get(sharedCPUs string, opts ...func(mf *Manifests)) (*Manifests, error) {
@@ -52,7 +62,11 @@ func Get(sharedCPUs string, opts ...func(mf *Manifests)) (*Manifests, error) {
 		"daemonset.yaml":                 &mf.DS,
 		"role.yaml":                      &mf.Role,
 		"rolebinding.yaml":               &mf.RB,
+		"clusterrole.yaml":               &mf.CR,
+		"clusterrolebinding.yaml":        &mf.CRB,
 		"securitycontextconstraint.yaml": &mf.SCC,
+		"service.yaml":                   &mf.Svc,
+		"servicemonitor.yaml":            &mf.SM,
 	}
 
 	files, err := dir.ReadDir("yamls")
@@ -110,6 +124,8 @@ func WithNamespace(ns string) func(mf *Manifests) {
 		mf.Role.Namespace = ns
 		mf.RB.Namespace = ns
 		mf.SA.Namespace = ns
+		mf.Svc.Namespace = ns
+		mf.SM.SetNamespace(ns)
 	}
 }
 
@@ -120,7 +136,40 @@ func WithName(name string) func(mf *Manifests) {
 		mf.SA.Name = name
 		mf.RB.Name = name
 		mf.RB.RoleRef.Name = mf.Role.Name
+		mf.CR.Name = name
+		mf.CRB.Name = name
+		mf.CRB.RoleRef.Name = mf.CR.Name
 		mf.SCC.Name = name
+		mf.Svc.Name = name + "-metrics"
+		mf.SM.SetName(name)
+	}
+}
+
+// PoolLabel distinguishes the pods of the DaemonSets of different node pools
+const PoolLabel = "mixedcpus.openshift.io/pool"
+
+// WithPool restricts the DaemonSet to the nodes of a pool,
+// so each pool can have its own DaemonSet with its own shared cpus.
+func WithPool(pool string, nodeSelector map[string]string) func(mf *Manifests) {
+	return func(mf *Manifests) {
+		spec := &mf.DS.Spec
+		if spec.Selector.MatchLabels == nil {
+			spec.Selector.MatchLabels = make(map[string]string)
+		}
+		spec.Selector.MatchLabels[PoolLabel] = pool
+		if spec.Template.Labels == nil {
+			spec.Template.Labels = make(map[string]string)
+		}
+		spec.Template.Labels[PoolLabel] = pool
+		spec.Template.Spec.NodeSelector = nodeSelector
+	}
+}
+
+// WithServiceMonitor deploys a Service and a ServiceMonitor
+// for scraping the plugin's metrics
+func WithServiceMonitor() func(mf *Manifests) {
+	return func(mf *Manifests) {
+		mf.serviceMonitor = true
 	}
 }
 
@@ -129,13 +178,19 @@ func (mf *Manifests) ToObjects() []client.Object {
 	if mf.NS.Name != "" {
 		objs = append(objs, &mf.NS)
 	}
-	return append(objs,
+	objs = append(objs,
 		&mf.DS,
 		&mf.Role,
 		&mf.RB,
+		&mf.CR,
+		&mf.CRB,
 		&mf.SA,
 		&mf.SCC,
 	)
+	if mf.serviceMonitor {
+		objs = append(objs, &mf.Svc, &mf.SM)
+	}
+	return objs
 }
 
 // SetSharedCPUs updates the container args under the
@@ -167,6 +222,8 @@ func updateServiceAccountInfo(mf *Manifests) {
 	mf.DS.Spec.Template.Spec.ServiceAccountName = saName
 	mf.RB.Subjects[0].Namespace = saNS
 	mf.RB.Subjects[0].Name = saName
+	mf.CRB.Subjects[0].Namespace = saNS
+	mf.CRB.Subjects[0].Name = saName
 
 	sa := saName
 	if saNS != "" {
@@ -104,6 +104,12 @@ func TestGet(t *testing.T) {
 	if reflect.DeepEqual(mf.RB, rbacv1.RoleBinding{}) {
 		t.Errorf("%q object is empty", mf.RB.Kind)
 	}
+	if reflect.DeepEqual(mf.CR, rbacv1.ClusterRole{}) {
+		t.Errorf("%q object is empty", mf.CR.Kind)
+	}
+	if reflect.DeepEqual(mf.CRB, rbacv1.ClusterRoleBinding{}) {
+		t.Errorf("%q object is empty", mf.CRB.Kind)
+	}
 	if reflect.DeepEqual(mf.SCC, securityv1.SecurityContextConstraints{}) {
 		t.Errorf("%q object is empty", mf.SCC.Kind)
 	}
@@ -146,4 +152,54 @@ func TestGet(t *testing.T) {
 	if mf.RB.Subjects[0].Namespace != mf.SA.Namespace {
 		t.Errorf("%q -> subject[0] -> namespace should be equal to %s", mf.RB.Kind, mf.SA.Namespace)
 	}
+	if mf.CRB.Subjects[0].Name != mf.SA.Name || mf.CRB.Subjects[0].Namespace != mf.SA.Namespace {
+		t.Errorf("%q -> subject[0] should be the service account %s/%s", mf.CRB.Kind, mf.SA.Namespace, mf.SA.Name)
+	}
+}
+
+func TestWithServiceMonitor(t *testing.T) {
+	mf, err := Get("0", WithNamespace("unit-test-ns"))
+	if err != nil {
+		t.Fatalf("failed to get manifests %v", err)
+	}
+	for _, obj := range mf.ToObjects() {
+		if obj == &mf.Svc || obj == &mf.SM {
+			t.Errorf("%q should not be deployed by default", obj.GetName())
+		}
+	}
+
+	mf, err = Get("0", WithNamespace("unit-test-ns"), WithServiceMonitor())
+	if err != nil {
+		t.Fatalf("failed to get manifests %v", err)
+	}
+	objs := mf.ToObjects()
+	if objs[len(objs)-2] != &mf.Svc || objs[len(objs)-1] != &mf.SM {
+		t.Fatalf("service and service monitor should be deployed")
+	}
+	if mf.SM.GetKind() != "ServiceMonitor" || mf.SM.GetNamespace() != "unit-test-ns" {
+		t.Errorf("unexpected service monitor %s %s/%s", mf.SM.GetKind(), mf.SM.GetNamespace(), mf.SM.GetName())
+	}
+	if mf.Svc.Spec.Ports[0].Port != 9400 || mf.Svc.Namespace != "unit-test-ns" {
+		t.Errorf("unexpected service %+v", mf.Svc)
+	}
+}
+
+func TestWithPool(t *testing.T) {
+	nodeSelector := map[string]string{"node-role.kubernetes.io/worker-cnf": ""}
+	mf, err := Get("0", WithName("mixedcpus-plugin-cnf"), WithPool("cnf", nodeSelector))
+	if err != nil {
+		t.Fatalf("failed to get manifests %v", err)
+	}
+	if got := mf.DS.Spec.Selector.MatchLabels[PoolLabel]; got != "cnf" {
+		t.Errorf("unexpected selector pool label %q", got)
+	}
+	if got := mf.DS.Spec.Template.Labels[PoolLabel]; got != "cnf" {
+		t.Errorf("unexpected template pool label %q", got)
+	}
+	if !reflect.DeepEqual(mf.DS.Spec.Template.Spec.NodeSelector, nodeSelector) {
+		t.Errorf("unexpected node selector %v", mf.DS.Spec.Template.Spec.NodeSelector)
+	}
+	if mf.DS.Spec.Template.Spec.ServiceAccountName != "mixedcpus-plugin-cnf" {
+		t.Errorf("unexpected service account %q", mf.DS.Spec.Template.Spec.ServiceAccountName)
+	}
 }
@@ -0,0 +1,128 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "mixedcpus"
+
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+var (
+	// NRIRequests counts the NRI events handled by the plugin, by method and result
+	NRIRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "nri_requests_total",
+		Help:      "Number of NRI requests handled, by method and result.",
+	}, []string{"method", "result"})
+
+	// CFSQuotaUpdates counts the cfs quota writes into the pods' and containers' cgroups
+	CFSQuotaUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cfs_quota_updates_total",
+		Help:      "Number of CFS quota updates of containers with shared cpus, by result.",
+	}, []string{"result"})
+
+	// SharedCPUsContainers is the number of containers that are running with shared cpus
+	SharedCPUsContainers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "shared_cpus_containers",
+		Help:      "Number of containers that are running with shared cpus.",
+	})
+
+	// MutualCPUs is the size of the mutual cpuset
+	MutualCPUs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "mutual_cpus",
+		Help:      "Number of cpus in the mutual cpuset.",
+	})
+
+	// AdvertisedDevices is the number of devices advertised to kubelet, by resource
+	AdvertisedDevices = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "devices_advertised",
+		Help:      "Number of devices advertised to kubelet, by resource.",
+	}, []string{"resource"})
+
+	// AllocatedDevices is the number of devices allocated to containers, by resource
+	AllocatedDevices = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "devices_allocated",
+		Help:      "Number of devices allocated to containers, by resource.",
+	}, []string{"resource"})
+
+	// CPUTuningContainers counts the containers with shared cpus in pods that tune their cpus
+	// with CRI-O annotations, by what the plugin did about them
+	CPUTuningContainers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cpu_tuning_containers_total",
+		Help:      "Number of containers with shared cpus in pods that tune their cpus with CRI-O annotations, by action.",
+	}, []string{"action"})
+
+	// NRIReconnects counts the reconnections to the container runtime
+	NRIReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "nri_reconnects_total",
+		Help:      "Number of reconnections to the container runtime.",
+	})
+)
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		NRIRequests,
+		CFSQuotaUpdates,
+		SharedCPUsContainers,
+		MutualCPUs,
+		AdvertisedDevices,
+		AllocatedDevices,
+		CPUTuningContainers,
+		NRIReconnects,
+	)
+}
+
+// Result returns the result label of an operation
+func Result(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultSuccess
+}
+
+// Handler serves the plugin's metrics
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Serve exposes the metrics on /metrics, until the server fails
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	return http.ListenAndServe(addr, mux)
+}
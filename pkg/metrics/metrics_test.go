@@ -0,0 +1,56 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	NRIRequests.WithLabelValues("CreateContainer", Result(nil)).Inc()
+	NRIRequests.WithLabelValues("CreateContainer", Result(fmt.Errorf("fake failure"))).Inc()
+	MutualCPUs.Set(2)
+	AdvertisedDevices.WithLabelValues("mutualcpu").Set(16)
+
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`mixedcpus_nri_requests_total{method="CreateContainer",result="success"} 1`,
+		`mixedcpus_nri_requests_total{method="CreateContainer",result="error"} 1`,
+		`mixedcpus_mutual_cpus 2`,
+		`mixedcpus_devices_advertised{resource="mutualcpu"} 16`,
+		`mixedcpus_nri_reconnects_total 0`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metric %q is missing", want)
+		}
+	}
+}
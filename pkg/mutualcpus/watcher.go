@@ -0,0 +1,143 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mutualcpus
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+)
+
+// Handler is called with the new mutual cpus whenever they change
+type Handler func(cpus cpuset.CPUSet) error
+
+// Watcher watches a file that holds the mutual cpus list
+// and notifies its handlers whenever the list changes.
+// The file can be a projected ConfigMap key.
+type Watcher struct {
+	path     string
+	mu       sync.Mutex
+	cpus     cpuset.CPUSet
+	handlers []Handler
+}
+
+// NewWatcher returns a Watcher initialized with the mutual cpus found under path
+func NewWatcher(path string) (*Watcher, error) {
+	cpus, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{
+		path: path,
+		cpus: cpus,
+	}, nil
+}
+
+// CPUs returns the current mutual cpus
+func (w *Watcher) CPUs() cpuset.CPUSet {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.cpus
+}
+
+// AddHandler registers a handler to be called on every mutual cpus change
+func (w *Watcher) AddHandler(h Handler) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.handlers = append(w.handlers, h)
+}
+
+// Run watches the file until the context is done
+func (w *Watcher) Run(ctx context.Context) error {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer fw.Close()
+
+	// watch the directory rather than the file,
+	// because ConfigMap volumes are updated by swapping symlinks
+	if err := fw.Add(filepath.Dir(w.path)); err != nil {
+		return fmt.Errorf("failed to watch %q: %w", w.path, err)
+	}
+	glog.Infof("watching %q for mutual cpus changes", w.path)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-fw.Events:
+			if !ok {
+				return fmt.Errorf("file watcher for %q closed", w.path)
+			}
+			w.reload()
+		case err, ok := <-fw.Errors:
+			if !ok {
+				return fmt.Errorf("file watcher for %q closed", w.path)
+			}
+			glog.Errorf("file watcher for %q: %v", w.path, err)
+		}
+	}
+}
+
+func (w *Watcher) reload() {
+	cpus, err := read(w.path)
+	if err != nil {
+		// the file might be in the middle of an update,
+		// keep the current cpus until a valid content shows up
+		glog.V(4).Infof("failed to reload mutual cpus: %v", err)
+		return
+	}
+
+	w.mu.Lock()
+	if cpus.Equals(w.cpus) {
+		w.mu.Unlock()
+		return
+	}
+	glog.Infof("mutual cpus changed from %q to %q", w.cpus.String(), cpus.String())
+	w.cpus = cpus
+	handlers := append([]Handler{}, w.handlers...)
+	w.mu.Unlock()
+
+	for _, h := range handlers {
+		if err := h(cpus); err != nil {
+			glog.Errorf("failed to apply mutual cpus %q: %v", cpus.String(), err)
+		}
+	}
+}
+
+func read(path string) (cpuset.CPUSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cpuset.CPUSet{}, fmt.Errorf("failed to read mutual cpus file %q: %w", path, err)
+	}
+	cpus, err := cpuset.Parse(strings.TrimSpace(string(data)))
+	if err != nil {
+		return cpuset.CPUSet{}, fmt.Errorf("failed to parse cpuset %q: %w", string(data), err)
+	}
+	if cpus.Size() == 0 {
+		return cpuset.CPUSet{}, fmt.Errorf("there has to be at least one mutual CPU")
+	}
+	return cpus, nil
+}
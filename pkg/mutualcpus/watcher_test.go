@@ -0,0 +1,125 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mutualcpus
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+)
+
+func TestNewWatcher(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+		want    string
+		isError bool
+	}{
+		{
+			name:    "valid cpus",
+			content: "0-2,5\n",
+			want:    "0-2,5",
+		},
+		{
+			name:    "empty file",
+			content: "",
+			isError: true,
+		},
+		{
+			name:    "bad format",
+			content: "1,b",
+			isError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cpus")
+			if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			w, err := NewWatcher(path)
+			if tc.isError {
+				if err == nil {
+					t.Fatalf("expected error for content %q", tc.content)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.CPUs().String() != tc.want {
+				t.Fatalf("unexpected cpus; want: %q got: %q", tc.want, w.CPUs().String())
+			}
+		})
+	}
+}
+
+func TestWatcherRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cpus")
+	if err := os.WriteFile(path, []byte("0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWatcher(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := make(chan cpuset.CPUSet, 10)
+	w.AddHandler(func(cpus cpuset.CPUSet) error {
+		changes <- cpus
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- w.Run(ctx)
+	}()
+
+	// give the watcher time to start watching
+	time.Sleep(100 * time.Millisecond)
+
+	// invalid content should be ignored
+	if err := os.WriteFile(path, []byte("bad"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("1-3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case cpus := <-changes:
+		if cpus.String() != "1-3" {
+			t.Fatalf("unexpected cpus; want: %q got: %q", "1-3", cpus.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for mutual cpus change")
+	}
+	if w.CPUs().String() != "1-3" {
+		t.Fatalf("unexpected current cpus; want: %q got: %q", "1-3", w.CPUs().String())
+	}
+
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+}
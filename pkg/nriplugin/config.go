@@ -0,0 +1,133 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"fmt"
+	"reflect"
+
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+
+	"github.com/golang/glog"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/cgroups"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/config"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/metrics"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/mutualcpus"
+)
+
+// AddMutualCPUsHandler registers a handler that is called
+// when the runtime configuration changes the mutual cpus
+func (p *Plugin) AddMutualCPUsHandler(h mutualcpus.Handler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mutualCPUsHandlers = append(p.mutualCPUsHandlers, h)
+}
+
+// applyRuntimeConfig applies the configuration given by the runtime on top of the plugin's configuration.
+// The mutual cpus, the runtime, the cfs quota, opt-in and cpu tuning policies take effect right away.
+// The rest of the fields are served by the device plugin, so changing them requires a restart.
+func (p *Plugin) applyRuntimeConfig(data string) error {
+	cfg, err := config.Parse([]byte(data))
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	cur := p.Config
+	p.mu.Unlock()
+	if cur != nil {
+		immutable := map[string][2]interface{}{
+			"sharedPools": {cur.SharedPools, cfg.SharedPools},
+			"numaAware":   {cur.NUMAAware, cfg.NUMAAware},
+			"resources":   {cur.Resources, cfg.Resources},
+			"devices":     {cur.Devices, cfg.Devices},
+		}
+		for field, values := range immutable {
+			if !reflect.DeepEqual(values[0], values[1]) {
+				glog.Warningf("runtime configuration: %s can not be changed at runtime, keeping %+v", field, values[0])
+			}
+		}
+	}
+
+	var rt cgroups.Runtime
+	if cfg.Runtime != "" {
+		if rt, err = cgroups.ParseRuntime(cfg.Runtime); err != nil {
+			return err
+		}
+	}
+
+	cpus, err := cpuset.Parse(cfg.MutualCPUs)
+	if err != nil {
+		return fmt.Errorf("failed to parse cpuset %q: %w", cfg.MutualCPUs, err)
+	}
+
+	p.mu.Lock()
+	if rt != "" {
+		p.Runtime = rt
+	}
+	if cur != nil {
+		merged := *cur
+		merged.MutualCPUs = cfg.MutualCPUs
+		merged.Runtime = cfg.Runtime
+		merged.CFSQuotaPolicy = cfg.CFSQuotaPolicy
+		merged.SharedMilliCPUs = cfg.SharedMilliCPUs
+		merged.OptInPolicy = cfg.OptInPolicy
+		merged.CPUTuningPolicy = cfg.CPUTuningPolicy
+		p.Config = &merged
+	} else {
+		p.Config = cfg
+	}
+	var oldCPUs cpuset.CPUSet
+	if p.MutualCPUs != nil {
+		oldCPUs = *p.MutualCPUs
+	}
+	changed := !oldCPUs.Equals(cpus)
+	if changed {
+		// Synchronize follows the configuration, and it should drop
+		// the former mutual cpus from the containers that are running with them
+		p.formerMutualCPUs = oldCPUs.Difference(cpus)
+		p.MutualCPUs = &cpus
+	}
+	handlers := p.mutualCPUsHandlers
+	p.mu.Unlock()
+
+	glog.Infof("runtime configuration: mutual cpus %q, cfs quota policy %q, opt-in policy %q, cpu tuning policy %q",
+		cpus.String(), cfg.CFSQuotaPolicy, cfg.OptInPolicy, cfg.CPUTuningPolicy)
+	if !changed {
+		return nil
+	}
+	metrics.MutualCPUs.Set(float64(cpus.Size()))
+	for _, h := range handlers {
+		if err := h(cpus); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Plugin) getRuntime() cgroups.Runtime {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Runtime
+}
+
+func (p *Plugin) getFormerMutualCPUs() cpuset.CPUSet {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.formerMutualCPUs
+}
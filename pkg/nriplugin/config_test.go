@@ -0,0 +1,92 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"testing"
+
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/cgroups"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/config"
+)
+
+func TestConfigureRuntimeConfig(t *testing.T) {
+	oldCPUs := cpuset.New(0, 1)
+	cur := config.Default()
+	cur.MutualCPUs = oldCPUs.String()
+	p := &Plugin{MutualCPUs: &oldCPUs, Config: cur}
+
+	var handled []cpuset.CPUSet
+	p.AddMutualCPUsHandler(func(cpus cpuset.CPUSet) error {
+		handled = append(handled, cpus)
+		return nil
+	})
+
+	// the devices limit can not be changed at runtime, so it is kept
+	_, err := p.Configure("mutualCPUs: \"1-2\"\nruntime: containerd\ndevices:\n  limit: 64", "cri-o", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := cpuset.New(1, 2); !p.MutualCPUs.Equals(want) {
+		t.Fatalf("unexpected mutual cpus; want: %q got: %q", want.String(), p.MutualCPUs.String())
+	}
+	if len(handled) != 1 || !handled[0].Equals(cpuset.New(1, 2)) {
+		t.Fatalf("expected the handlers to get the new mutual cpus, got: %v", handled)
+	}
+	if want := cpuset.New(0); !p.getFormerMutualCPUs().Equals(want) {
+		t.Fatalf("unexpected former mutual cpus; want: %q got: %q", want.String(), p.getFormerMutualCPUs().String())
+	}
+	if p.Runtime != cgroups.RuntimeContainerd {
+		t.Fatalf("unexpected runtime; want: %q got: %q", cgroups.RuntimeContainerd, p.Runtime)
+	}
+	if p.Config.MutualCPUs != "1-2" || p.Config.Devices.Limit != cur.Devices.Limit {
+		t.Fatalf("unexpected config: %+v", p.Config)
+	}
+
+	// the same mutual cpus do not trigger the handlers
+	if _, err := p.Configure(`mutualCPUs: "1-2"`, "cri-o", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 1 {
+		t.Fatalf("unexpected handler calls: %d", len(handled))
+	}
+
+	if _, err := p.Configure(`mutualCPUs: "x"`, "cri-o", "v1"); err == nil {
+		t.Fatalf("expected an error for an invalid runtime configuration")
+	}
+}
+
+// TestConfigureNodeStatus runs with -race, the runtime is set while the node status is reported
+func TestConfigureNodeStatus(t *testing.T) {
+	cpus := cpuset.New(0)
+	p := &Plugin{MutualCPUs: &cpus}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = p.NodeStatus()
+		}
+	}()
+	if _, err := p.Configure("mutualCPUs: \"0\"\nruntime: crio", "cri-o", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if got := p.NodeStatus().Runtime; got != string(cgroups.RuntimeCrio) {
+		t.Fatalf("unexpected runtime; want: %q got: %q", cgroups.RuntimeCrio, got)
+	}
+}
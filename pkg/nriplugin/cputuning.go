@@ -0,0 +1,191 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"fmt"
+
+	"github.com/containerd/nri/pkg/api"
+	"github.com/golang/glog"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/cgroups"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/config"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/deviceplugin"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/metrics"
+)
+
+// CRI-O pod annotations that tune the cpus of the pod's containers, i.e. the whole cpuset of the containers
+const (
+	crioCPULoadBalancingAnnotation = "cpu-load-balancing.crio.io"
+	crioIRQLoadBalancingAnnotation = "irq-load-balancing.crio.io"
+	crioCPUCStatesAnnotation       = "cpu-c-states.crio.io"
+)
+
+// cpuTuningAnnotations returns the CRI-O annotations of the pod that tune the cpus of its containers.
+// The shared cpus must not be tuned along with the containers' exclusive cpus,
+// since the containers of other pods depend on them.
+func cpuTuningAnnotations(pod *api.PodSandbox) []string {
+	annotations := pod.GetAnnotations()
+	var found []string
+	for _, a := range []string{crioCPULoadBalancingAnnotation, crioIRQLoadBalancingAnnotation} {
+		if annotations[a] == crioDisable {
+			found = append(found, a)
+		}
+	}
+	// any value of the c-states annotation acts on the cpus
+	if _, ok := annotations[crioCPUCStatesAnnotation]; ok {
+		found = append(found, crioCPUCStatesAnnotation)
+	}
+	return found
+}
+
+func (p *Plugin) cpuTuningPolicy() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Config == nil || p.Config.CPUTuningPolicy == "" {
+		return config.CPUTuningExclusive
+	}
+	return p.Config.CPUTuningPolicy
+}
+
+// deferSharedCPUs checks whether the shared cpus of the container are added only once it started,
+// so the runtime tunes the container's exclusive cpus only.
+// Containers that should not get the shared cpus at all are refused with an error.
+func (p *Plugin) deferSharedCPUs(pod *api.PodSandbox, ctr *api.Container) (bool, error) {
+	tuning := cpuTuningAnnotations(pod)
+	if len(tuning) == 0 {
+		return false, nil
+	}
+	uniqueName := getCtrUniqueName(pod, ctr)
+	if p.cpuTuningPolicy() == config.CPUTuningReject {
+		metrics.CPUTuningContainers.WithLabelValues("rejected").Inc()
+		glog.Warningf("container %q requests shared cpus, refusing it since its pod tunes its cpus with %v", uniqueName, tuning)
+		return false, fmt.Errorf("container %q requests shared cpus, but its pod tunes its cpus with %v", uniqueName, tuning)
+	}
+	glog.Infof("container %q: its pod tunes its cpus with %v, the shared cpus are added once the container started", uniqueName, tuning)
+	return true, nil
+}
+
+// PostStartContainer adds the shared cpus to containers that were created with their exclusive cpus only,
+// once the runtime tuned them.
+func (p *Plugin) PostStartContainer(pod *api.PodSandbox, ctr *api.Container) error {
+	if len(cpuTuningAnnotations(pod)) == 0 || p.cpuTuningPolicy() != config.CPUTuningExclusive {
+		return nil
+	}
+	pools, _ := p.requestedPools(pod, ctr)
+	if len(pools) == 0 {
+		return nil
+	}
+	p.deferContainer(pod, ctr)
+	// the runtime does not take updates from plugins while it notifies them
+	go p.addDeferredSharedCPUs(pod, ctr, pools)
+	return nil
+}
+
+// deferContainer marks the container as waiting for its shared cpus,
+// until they are added or the container is stopped
+func (p *Plugin) deferContainer(pod *api.PodSandbox, ctr *api.Container) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.deferred == nil {
+		p.deferred = make(map[string]string)
+	}
+	p.deferred[ctr.GetId()] = pod.GetId()
+}
+
+// undeferContainer returns whether the container was still waiting for its shared cpus, and stops waiting
+func (p *Plugin) undeferContainer(ctrId string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.deferred[ctrId]
+	delete(p.deferred, ctrId)
+	return ok
+}
+
+// isDeferred checks whether the container is waiting for its shared cpus
+func (p *Plugin) isDeferred(ctrId string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.deferred[ctrId]
+	return ok
+}
+
+// addDeferredSharedCPUs adds the shared cpus to the started container with an unsolicited update.
+// Containers that were stopped in the meantime are left alone.
+func (p *Plugin) addDeferredSharedCPUs(pod *api.PodSandbox, ctr *api.Container, pools []deviceplugin.Pool) {
+	uniqueName := getCtrUniqueName(pod, ctr)
+	update, ok, err := p.trackDeferredSharedCPUs(pod, ctr, pools)
+	if err != nil {
+		p.undeferContainer(ctr.GetId())
+		glog.Errorf("failed to add shared cpus to container %q: %v", uniqueName, err)
+		return
+	}
+	if !ok {
+		glog.Infof("container %q stopped before its shared cpus were added", uniqueName)
+		return
+	}
+	s := p.getStub()
+	if s == nil {
+		err = fmt.Errorf("no NRI stub to send container updates with")
+	} else {
+		var failed []*api.ContainerUpdate
+		glog.V(4).Infof("sending unsolicited update to runtime: %+v", update)
+		if failed, err = s.UpdateContainers([]*api.ContainerUpdate{update}); err == nil && len(failed) > 0 {
+			err = fmt.Errorf("the runtime failed to update the container")
+		}
+	}
+	// the container released its shared cpus if it stopped while the update was sent
+	if !p.undeferContainer(ctr.GetId()) {
+		glog.Infof("container %q stopped while its shared cpus were added", uniqueName)
+		return
+	}
+	if err != nil {
+		metrics.CPUTuningContainers.WithLabelValues("failed").Inc()
+		glog.Errorf("failed to add shared cpus to container %q: %v", uniqueName, err)
+		p.setContainerError(ctr.GetId(), fmt.Errorf("failed to add shared cpus once the container started: %w", err))
+		return
+	}
+	metrics.CPUTuningContainers.WithLabelValues("deferred").Inc()
+	glog.Infof("container %q started with cpus tuned by the runtime, shared cpus added: cpus %q", uniqueName, update.GetLinux().GetResources().GetCpu().GetCpus())
+	p.setContainerError(ctr.GetId(), nil)
+	// the pod's quota and the burst, as if the runtime updated the container
+	_ = p.PostUpdateContainer(pod, ctr)
+}
+
+// trackDeferredSharedCPUs returns the update that adds the shared cpus to the container,
+// and tracks the container with them, unless it was stopped already.
+// It is serialized with releasing containers, so a stopped container is never tracked again.
+func (p *Plugin) trackDeferredSharedCPUs(pod *api.PodSandbox, ctr *api.Container, pools []deviceplugin.Pool) (*api.ContainerUpdate, bool, error) {
+	p.releaseMu.Lock()
+	defer p.releaseMu.Unlock()
+	if !p.isDeferred(ctr.GetId()) {
+		return nil, false, nil
+	}
+	var original *cgroups.CFSQuota
+	if ctr.GetLinux().GetResources().GetCpu().GetQuota().GetValue() != 0 {
+		q := ctrCFSQuota(ctr.GetLinux().GetResources())
+		original = &q
+	}
+	update, quota, err := p.sharedCPUsUpdate(pod, ctr, pools)
+	if err != nil {
+		return nil, false, err
+	}
+	if quota != nil {
+		p.growPodQuota(pod, ctr, *quota, original, true)
+	}
+	return update, true, nil
+}
@@ -0,0 +1,377 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/nri/pkg/api"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/cgroups"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/config"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/deviceplugin"
+	e2ecpuset "github.com/openshift-kni/mixed-cpu-node-plugin/test/e2e/cpuset"
+)
+
+func TestCPUTuningAnnotations(t *testing.T) {
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		want        []string
+	}{
+		{
+			name: "no annotations",
+		},
+		{
+			name:        "cpu load balancing disabled",
+			annotations: map[string]string{crioCPULoadBalancingAnnotation: "disable"},
+			want:        []string{crioCPULoadBalancingAnnotation},
+		},
+		{
+			name:        "cpu load balancing enabled",
+			annotations: map[string]string{crioCPULoadBalancingAnnotation: "enable"},
+		},
+		{
+			name:        "irq load balancing disabled",
+			annotations: map[string]string{crioIRQLoadBalancingAnnotation: "disable"},
+			want:        []string{crioIRQLoadBalancingAnnotation},
+		},
+		{
+			name:        "c-states",
+			annotations: map[string]string{crioCPUCStatesAnnotation: "max_latency:10"},
+			want:        []string{crioCPUCStatesAnnotation},
+		},
+		{
+			name: "all of them",
+			annotations: map[string]string{
+				crioCPULoadBalancingAnnotation: "disable",
+				crioIRQLoadBalancingAnnotation: "disable",
+				crioCPUCStatesAnnotation:       "disable",
+				crioCPUQuotaAnnotation:         "disable",
+			},
+			want: []string{crioCPULoadBalancingAnnotation, crioIRQLoadBalancingAnnotation, crioCPUCStatesAnnotation},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := cpuTuningAnnotations(makePodSandbox("test-sb", withAnnotations(tc.annotations)))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("unexpected annotations; want: %v got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCreateContainerCPUTuning(t *testing.T) {
+	testCases := []struct {
+		name        string
+		policy      string
+		annotations map[string]string
+		wantErr     string
+		wantCpus    string
+		wantTracked bool
+	}{
+		{
+			name:        "no cpu tuning",
+			policy:      config.CPUTuningReject,
+			wantCpus:    "0-2",
+			wantTracked: true,
+		},
+		{
+			name:        "rejected",
+			policy:      config.CPUTuningReject,
+			annotations: map[string]string{crioIRQLoadBalancingAnnotation: "disable"},
+			wantErr:     crioIRQLoadBalancingAnnotation,
+		},
+		{
+			name:        "exclusive cpus only",
+			policy:      config.CPUTuningExclusive,
+			annotations: map[string]string{crioCPULoadBalancingAnnotation: "disable"},
+			wantCpus:    "1,2",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mutualCPUs := e2ecpuset.MustParse("0")
+			p := &Plugin{
+				MutualCPUs: &mutualCPUs,
+				Runtime:    cgroups.RuntimeCrio,
+				Config:     &config.Config{CPUTuningPolicy: tc.policy},
+				cgroups:    &fakeCgroupsAdapter{},
+			}
+			sb := makePodSandbox("test-sb", withAnnotations(tc.annotations))
+			ctr := makeContainer("test-ctr",
+				withLinuxResources("1,2", 200000),
+				withPeriod(100000),
+				withEnv(deviceplugin.EnvVarName+"=0"))
+			_, _, err := p.CreateContainer(sb, ctr)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			cpu := ctr.GetLinux().GetResources().GetCpu()
+			if got := cpu.GetCpus(); got != tc.wantCpus {
+				t.Errorf("unexpected cpus; want: %q got: %q", tc.wantCpus, got)
+			}
+			if got := cpu.GetQuota().GetValue(); got != 200000 {
+				t.Errorf("expected the quota to be left to the cgroups; want: %d got: %d", 200000, got)
+			}
+			if _, tracked := p.containers[ctr.GetId()]; tracked != tc.wantTracked {
+				t.Errorf("unexpected tracking of the container; want: %v got: %v", tc.wantTracked, tracked)
+			}
+			// the quota of a container with its exclusive cpus only is kubelet's
+			if pending := p.getPending(ctr.GetId()) != nil; pending != tc.wantTracked {
+				t.Errorf("unexpected pending cfs quota; want: %v got: %v", tc.wantTracked, pending)
+			}
+		})
+	}
+}
+
+func TestAddDeferredSharedCPUs(t *testing.T) {
+	fca := &fakeCgroupsAdapter{}
+	fs := &fakeStub{}
+	mutualCPUs := e2ecpuset.MustParse("0")
+	p := &Plugin{
+		MutualCPUs: &mutualCPUs,
+		Runtime:    cgroups.RuntimeCrio,
+		Stub:       fs,
+		cgroups:    fca,
+	}
+	sb := makePodSandbox("test-sb", withAnnotations(map[string]string{crioCPUCStatesAnnotation: "disable"}))
+	newCtr := func() *api.Container {
+		ctr := makeContainer("test-ctr",
+			withLinuxResources("1,2", 200000),
+			withPeriod(100000),
+			withEnv(deviceplugin.EnvVarName+"=0"),
+			withPodSandboxId(sb.GetId()))
+		ctr.Id = "test-ctr-id"
+		return ctr
+	}
+	if err := p.RunPodSandbox(sb); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := p.CreateContainer(sb, newCtr()); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PostCreateContainer(sb, newCtr()); err != nil {
+		t.Fatal(err)
+	}
+	if len(fca.pod) != 0 || len(fca.ctr) != 0 {
+		t.Fatalf("expected no cfs quota writes before the container started; pods: %v containers: %v", fca.pod, fca.ctr)
+	}
+
+	// the plugin restarted before the container started
+	created := newCtr()
+	created.State = api.ContainerState_CONTAINER_CREATED
+	updates, err := p.Synchronize([]*api.PodSandbox{sb}, []*api.Container{created})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 0 {
+		t.Fatalf("expected no updates of the created container, got: %+v", updates)
+	}
+
+	pools, _ := p.requestedPools(sb, newCtr())
+	p.deferContainer(sb, newCtr())
+	p.addDeferredSharedCPUs(sb, newCtr(), pools)
+	if len(fs.updates) != 1 {
+		t.Fatalf("expected exactly one update, got: %d", len(fs.updates))
+	}
+	cpu := fs.updates[0].GetLinux().GetResources().GetCpu()
+	if got := cpu.GetCpus(); got != "0-2" {
+		t.Errorf("unexpected cpus; want: %q got: %q", "0-2", got)
+	}
+	if got := cpu.GetQuota().GetValue(); got != 300000 {
+		t.Errorf("unexpected quota; want: %d got: %d", 300000, got)
+	}
+	if got := fca.pod[sb.GetLinux().GetCgroupParent()].Quota; got != 300000 {
+		t.Errorf("unexpected pod quota; want: %d got: %d", 300000, got)
+	}
+	st := p.NodeStatus()
+	if len(st.Containers) != 1 || st.Containers[0].LastError != "" {
+		t.Fatalf("expected the container to be tracked without errors, got: %+v", st.Containers)
+	}
+
+	// the runtime refuses the update
+	p.Stub = nil
+	p.deferContainer(sb, newCtr())
+	p.addDeferredSharedCPUs(sb, newCtr(), pools)
+	if st := p.NodeStatus(); len(st.Containers) != 1 || st.Containers[0].LastError == "" {
+		t.Fatalf("expected the container's error to be reported, got: %+v", st.Containers)
+	}
+}
+
+func TestPostStartContainerCPUTuning(t *testing.T) {
+	testCases := []struct {
+		name        string
+		policy      string
+		annotations map[string]string
+		wantUpdate  bool
+	}{
+		{
+			name:        "shared cpus added once started",
+			annotations: map[string]string{crioCPUCStatesAnnotation: "disable"},
+			wantUpdate:  true,
+		},
+		{
+			name: "pod without tuning annotations",
+		},
+		{
+			name:        "reject policy",
+			policy:      config.CPUTuningReject,
+			annotations: map[string]string{crioCPUCStatesAnnotation: "disable"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := &fakeStub{updated: make(chan []*api.ContainerUpdate, 1)}
+			fpr := &fakePodResources{}
+			mutualCPUs := e2ecpuset.MustParse("0")
+			p := &Plugin{
+				MutualCPUs:   &mutualCPUs,
+				Runtime:      cgroups.RuntimeCrio,
+				Stub:         fs,
+				Config:       &config.Config{CPUTuningPolicy: tc.policy},
+				PodResources: fpr,
+				cgroups:      &fakeCgroupsAdapter{},
+			}
+			sb := makePodSandbox("test-sb", withAnnotations(tc.annotations))
+			ctr := makeContainer("test-ctr",
+				withLinuxResources("1,2", 200000),
+				withPeriod(100000),
+				withEnv(deviceplugin.EnvVarName+"=0"))
+			if err := p.PostStartContainer(sb, ctr); err != nil {
+				t.Fatal(err)
+			}
+			if !tc.wantUpdate {
+				// kubelet is not asked about containers whose shared cpus are not deferred
+				if p.isDeferred(ctr.GetId()) || fpr.calls != 0 {
+					t.Fatalf("expected the container to be left alone; deferred: %v, kubelet queries: %d",
+						p.isDeferred(ctr.GetId()), fpr.calls)
+				}
+				return
+			}
+			// kubelet is asked once, not again by the deferred update
+			if fpr.calls != 1 {
+				t.Errorf("unexpected kubelet queries; want: %d got: %d", 1, fpr.calls)
+			}
+
+			select {
+			case updates := <-fs.updated:
+				if len(updates) != 1 {
+					t.Fatalf("expected exactly one update, got: %d", len(updates))
+				}
+				if got := updates[0].GetLinux().GetResources().GetCpu().GetCpus(); got != "0-2" {
+					t.Errorf("unexpected cpus; want: %q got: %q", "0-2", got)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for the container's update")
+			}
+			// the pod's quota is raised before the container's update is sent
+			if quota, managed := p.podCFSQuota(sb.GetId()); !managed || quota.Quota != 300000 {
+				t.Errorf("unexpected pod quota; want: %d got: %d managed: %v", 300000, quota.Quota, managed)
+			}
+		})
+	}
+}
+
+func TestDeferredSharedCPUsStopped(t *testing.T) {
+	testCases := []struct {
+		name string
+		// stopWhileUpdating stops the container while its update is sent, otherwise before
+		stopWhileUpdating bool
+		wantUpdates       int
+		wantManaged       bool
+	}{
+		{
+			name: "stopped before the shared cpus are added",
+		},
+		{
+			name:              "stopped while the shared cpus are added",
+			stopWhileUpdating: true,
+			wantUpdates:       1,
+			wantManaged:       true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fca := &fakeCgroupsAdapter{}
+			fs := &fakeStub{}
+			mutualCPUs := e2ecpuset.MustParse("0")
+			p := &Plugin{
+				MutualCPUs: &mutualCPUs,
+				Runtime:    cgroups.RuntimeCrio,
+				Stub:       fs,
+				cgroups:    fca,
+			}
+			sb := makePodSandbox("test-sb", withAnnotations(map[string]string{crioCPUCStatesAnnotation: "disable"}))
+			ctr := makeContainer("test-ctr",
+				withLinuxResources("1,2", 200000),
+				withPeriod(100000),
+				withEnv(deviceplugin.EnvVarName+"=0"))
+			if _, _, err := p.CreateContainer(sb, ctr); err != nil {
+				t.Fatal(err)
+			}
+			pools, _ := p.requestedPools(sb, ctr)
+			p.deferContainer(sb, ctr)
+
+			stop := func() {
+				if _, err := p.StopContainer(sb, ctr); err != nil {
+					t.Error(err)
+				}
+			}
+			if tc.stopWhileUpdating {
+				fs.onUpdate = stop
+			} else {
+				stop()
+			}
+			p.addDeferredSharedCPUs(sb, ctr, pools)
+
+			if got := len(fs.getUpdates()); got != tc.wantUpdates {
+				t.Fatalf("unexpected number of updates; want: %d got: %d", tc.wantUpdates, got)
+			}
+			// the stopped container is not tracked again with the shared cpus
+			if st := p.NodeStatus(); len(st.Containers) != 0 {
+				t.Fatalf("expected no tracked containers, got: %+v", st.Containers)
+			}
+			if p.isDeferred(ctr.GetId()) {
+				t.Fatalf("expected the container to stop waiting for its shared cpus")
+			}
+			// a container that ran with shared cpus keeps its original share for its restart
+			quota, managed := p.podCFSQuota(sb.GetId())
+			if managed != tc.wantManaged {
+				t.Fatalf("unexpected management of the pod's quota; want: %v got: %v", tc.wantManaged, managed)
+			}
+			if managed && (quota.Quota != 200000 || fca.pod[sb.GetLinux().GetCgroupParent()].Quota != 200000) {
+				t.Fatalf("unexpected pod quota; want: %d got: %d, written: %d",
+					200000, quota.Quota, fca.pod[sb.GetLinux().GetCgroupParent()].Quota)
+			}
+		})
+	}
+}
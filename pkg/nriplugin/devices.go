@@ -0,0 +1,106 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"context"
+	"sort"
+
+	"github.com/containerd/nri/pkg/api"
+	"github.com/golang/glog"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/metrics"
+)
+
+// DeviceReleaser returns devices to the device plugin once they are no longer in use
+type DeviceReleaser interface {
+	Release(resourceName string, ids []string)
+}
+
+// PodResourcesLister reports the devices that kubelet assigned to a container,
+// keyed by the fully qualified resource name
+type PodResourcesLister interface {
+	ContainerDevices(ctx context.Context, namespace, podName, containerName string) (map[string][]string, error)
+}
+
+// trackDevices records the devices that were allocated to the container's pod,
+// keyed by resource name
+func (p *Plugin) trackDevices(pod *api.PodSandbox, devices map[string][]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for resourceName, ids := range devices {
+		if len(ids) == 0 {
+			continue
+		}
+		if p.podDevices == nil {
+			p.podDevices = make(map[string]map[string]map[string]bool)
+		}
+		if p.podDevices[pod.GetId()] == nil {
+			p.podDevices[pod.GetId()] = make(map[string]map[string]bool)
+		}
+		resources := p.podDevices[pod.GetId()]
+		if resources[resourceName] == nil {
+			resources[resourceName] = make(map[string]bool)
+		}
+		for _, id := range ids {
+			resources[resourceName][id] = true
+		}
+	}
+}
+
+// RemovePodSandbox releases the devices that were allocated to the pod
+// and drops the bookkeeping of the pod and of its containers.
+// Kubelet keeps the devices assigned to the pod as long as it exists,
+// and restarted containers reuse them, so they are released only along with the pod.
+func (p *Plugin) RemovePodSandbox(pod *api.PodSandbox) error {
+	p.mu.Lock()
+	resources := p.podDevices[pod.GetId()]
+	delete(p.podDevices, pod.GetId())
+	delete(p.podQuotas, pod.GetId())
+	// the runtime may not report the removal of every container
+	for id, mc := range p.containers {
+		if mc.podId == pod.GetId() {
+			delete(p.containers, id)
+		}
+	}
+	metrics.SharedCPUsContainers.Set(float64(len(p.containers)))
+	for id, pq := range p.pending {
+		if pq.podId == pod.GetId() {
+			delete(p.pending, id)
+		}
+	}
+	for id, podId := range p.deferred {
+		if podId == pod.GetId() {
+			delete(p.deferred, id)
+		}
+	}
+	p.mu.Unlock()
+
+	if p.Devices == nil {
+		return nil
+	}
+	for resourceName, devices := range resources {
+		ids := make([]string, 0, len(devices))
+		for id := range devices {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		glog.V(2).Infof("pod %s/%s removed, releasing %q devices %v", pod.GetNamespace(), pod.GetName(), resourceName, ids)
+		p.Devices.Release(resourceName, ids)
+	}
+	return nil
+}
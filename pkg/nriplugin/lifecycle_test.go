@@ -0,0 +1,309 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"testing"
+
+	"github.com/containerd/nri/pkg/api"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/cgroups"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/deviceplugin"
+	e2ecpuset "github.com/openshift-kni/mixed-cpu-node-plugin/test/e2e/cpuset"
+)
+
+func TestContainerLifecycle(t *testing.T) {
+	fca := &fakeCgroupsAdapter{}
+	fr := &fakeReleaser{}
+	mutualCPUs := e2ecpuset.MustParse("0")
+	p := &Plugin{
+		MutualCPUs: &mutualCPUs,
+		Runtime:    cgroups.RuntimeCrio,
+		Devices:    fr,
+		cgroups:    fca,
+	}
+	sb := makePodSandbox("test-sb")
+	checkPodQuota := func(step string, want int64) {
+		t.Helper()
+		if got := fca.pod[sb.GetLinux().GetCgroupParent()].Quota; got != want {
+			t.Fatalf("%s: unexpected pod quota; want: %d got: %d", step, want, got)
+		}
+	}
+	checkTracked := func(step string, want int) {
+		t.Helper()
+		if got := len(p.NodeStatus().Containers); got != want {
+			t.Fatalf("%s: unexpected number of tracked containers; want: %d got: %d", step, want, got)
+		}
+	}
+	newShared := func() *api.Container {
+		return makeContainer("shared",
+			withLinuxResources("1,2", 200000),
+			withPeriod(100000),
+			withEnv(deviceplugin.EnvVarName+"=0", deviceplugin.DeviceIDsEnvVarName+"=7"))
+	}
+	start := func(ctr *api.Container) {
+		t.Helper()
+		if _, _, err := p.CreateContainer(sb, ctr); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.PostCreateContainer(sb, ctr); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.StartContainer(sb, ctr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stop := func(ctr *api.Container) {
+		t.Helper()
+		if _, err := p.StopContainer(sb, ctr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remove := func(ctr *api.Container) {
+		t.Helper()
+		if err := p.RemoveContainer(sb, ctr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := p.RunPodSandbox(sb); err != nil {
+		t.Fatal(err)
+	}
+	sidecar := makeContainer("sidecar", withLinuxResources("3", 100000), withPeriod(100000))
+	start(sidecar)
+	shared := newShared()
+	start(shared)
+	checkPodQuota("started", 400000)
+	checkTracked("started", 1)
+
+	stop(shared)
+	checkPodQuota("stopped", 300000)
+	checkTracked("stopped", 0)
+	if got := fca.ctr[shared.GetId()].Quota; got != 200000 {
+		t.Fatalf("unexpected quota of the stopped container; want: %d got: %d", 200000, got)
+	}
+
+	// kubelet restarts the container as a new instance
+	restarted := newShared()
+	start(restarted)
+	checkPodQuota("restarted", 400000)
+	checkTracked("restarted", 1)
+
+	// the former instance is garbage collected while the new one runs
+	remove(shared)
+	checkPodQuota("former instance removed", 400000)
+	checkTracked("former instance removed", 1)
+
+	// a removed container is gone for good, so it no longer counts in the pod's quota
+	remove(restarted)
+	checkPodQuota("removed", 100000)
+	checkTracked("removed", 0)
+
+	// the devices are released along with the pod only
+	if len(fr.released) != 0 {
+		t.Fatalf("expected no released devices before the pod is removed, got: %v", fr.released)
+	}
+	if err := p.RemovePodSandbox(sb); err != nil {
+		t.Fatal(err)
+	}
+	if len(fr.released) == 0 {
+		t.Fatalf("expected the pod's devices to be released")
+	}
+	if len(p.podQuotas) != 0 || len(p.pending) != 0 {
+		t.Fatalf("expected no pod bookkeeping left; quotas: %v pending: %v", p.podQuotas, p.pending)
+	}
+}
+
+func TestContainerLifecycleSynchronize(t *testing.T) {
+	testCases := []struct {
+		name string
+		// restart syncs a new instance of the plugin, instead of the one that saw the containers start
+		restart bool
+	}{
+		{
+			name: "reconnect",
+		},
+		{
+			name:    "restart",
+			restart: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fca := &fakeCgroupsAdapter{}
+			mutualCPUs := e2ecpuset.MustParse("0")
+			newPlugin := func() *Plugin {
+				return &Plugin{
+					MutualCPUs: &mutualCPUs,
+					Runtime:    cgroups.RuntimeCrio,
+					Devices:    &fakeReleaser{},
+					cgroups:    fca,
+				}
+			}
+			p := newPlugin()
+			sb := makePodSandbox("test-sb")
+			checkPodQuota := func(step string, want int64) {
+				t.Helper()
+				if got := fca.pod[sb.GetLinux().GetCgroupParent()].Quota; got != want {
+					t.Fatalf("%s: unexpected pod quota; want: %d got: %d", step, want, got)
+				}
+			}
+			if err := p.RunPodSandbox(sb); err != nil {
+				t.Fatal(err)
+			}
+			sidecar := makeContainer("sidecar", withLinuxResources("3", 100000), withPeriod(100000), withPodSandboxId(sb.GetId()))
+			shared := makeContainer("shared",
+				withLinuxResources("1,2", 200000),
+				withPeriod(100000),
+				withPodSandboxId(sb.GetId()),
+				withEnv(deviceplugin.EnvVarName+"=0", deviceplugin.DeviceIDsEnvVarName+"=7"))
+			for _, ctr := range []*api.Container{sidecar, shared} {
+				if _, _, err := p.CreateContainer(sb, ctr); err != nil {
+					t.Fatal(err)
+				}
+				if err := p.StartContainer(sb, ctr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			checkPodQuota("started", 400000)
+
+			if tc.restart {
+				p = newPlugin()
+			}
+			// the runtime reports the shared container as it runs, with the shared cpus and the raised quota
+			running := makeContainer("shared",
+				withLinuxResources("0-2", 300000),
+				withPeriod(100000),
+				withPodSandboxId(sb.GetId()),
+				withEnv(shared.GetEnv()...))
+			running.Id = shared.GetId()
+			if _, err := p.Synchronize([]*api.PodSandbox{sb}, []*api.Container{sidecar, running}); err != nil {
+				t.Fatal(err)
+			}
+			checkPodQuota("synchronized", 400000)
+
+			// the stopped container is rolled back to the quota kubelet gave it
+			if _, err := p.StopContainer(sb, running); err != nil {
+				t.Fatal(err)
+			}
+			checkPodQuota("stopped", 300000)
+			if got := fca.ctr[running.GetId()].Quota; got != 200000 {
+				t.Fatalf("unexpected quota of the stopped container; want: %d got: %d", 200000, got)
+			}
+		})
+	}
+}
+
+func TestContainerLifecycleExitedContainers(t *testing.T) {
+	testCases := []struct {
+		name string
+		ctr  *api.Container
+		// running is the pod's quota while the container runs
+		running int64
+	}{
+		{
+			name:    "init container",
+			ctr:     makeContainer("init", withLinuxResources("3-5", 300000), withPeriod(100000)),
+			running: 600000,
+		},
+		{
+			name:    "ephemeral container without limits",
+			ctr:     makeContainer("debugger", withLinuxResources("0-7", 0), withPeriod(100000)),
+			running: -1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fca := &fakeCgroupsAdapter{}
+			mutualCPUs := e2ecpuset.MustParse("0")
+			p := &Plugin{
+				MutualCPUs: &mutualCPUs,
+				Runtime:    cgroups.RuntimeCrio,
+				cgroups:    fca,
+			}
+			sb := makePodSandbox("test-sb")
+			checkPodQuota := func(step string, want int64) {
+				t.Helper()
+				if got := fca.pod[sb.GetLinux().GetCgroupParent()].Quota; got != want {
+					t.Fatalf("%s: unexpected pod quota; want: %d got: %d", step, want, got)
+				}
+			}
+			if err := p.RunPodSandbox(sb); err != nil {
+				t.Fatal(err)
+			}
+			shared := makeContainer("shared",
+				withLinuxResources("1,2", 200000),
+				withPeriod(100000),
+				withEnv(deviceplugin.EnvVarName+"=0"))
+			for _, ctr := range []*api.Container{shared, tc.ctr} {
+				if _, _, err := p.CreateContainer(sb, ctr); err != nil {
+					t.Fatal(err)
+				}
+				if err := p.PostCreateContainer(sb, ctr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			checkPodQuota("running", tc.running)
+
+			// the exited container does not run along with the rest anymore, whether it is removed or not
+			if _, err := p.StopContainer(sb, tc.ctr); err != nil {
+				t.Fatal(err)
+			}
+			checkPodQuota("exited", 300000)
+			if err := p.RemoveContainer(sb, tc.ctr); err != nil {
+				t.Fatal(err)
+			}
+			checkPodQuota("removed", 300000)
+		})
+	}
+}
+
+func TestRemovePodSandboxDropsContainers(t *testing.T) {
+	fca := &fakeCgroupsAdapter{}
+	mutualCPUs := e2ecpuset.MustParse("0")
+	p := &Plugin{
+		MutualCPUs: &mutualCPUs,
+		Runtime:    cgroups.RuntimeCrio,
+		cgroups:    fca,
+	}
+	sb := makePodSandbox("test-sb")
+	other := makePodSandbox("other-sb")
+	for _, pod := range []*api.PodSandbox{sb, other} {
+		ctr := makeContainer("test-ctr",
+			withLinuxResources("1,2", 200000),
+			withPeriod(100000),
+			withEnv(deviceplugin.EnvVarName+"=0"))
+		if _, _, err := p.CreateContainer(pod, ctr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the runtime did not report the containers' removal
+	if err := p.RemovePodSandbox(sb); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.containers) != 1 || len(p.pending) != 1 || len(p.podQuotas) != 1 {
+		t.Fatalf("expected only the other pod's bookkeeping to be left; containers: %d pending: %d pods: %d",
+			len(p.containers), len(p.pending), len(p.podQuotas))
+	}
+	for _, mc := range p.containers {
+		if mc.podId != other.GetId() {
+			t.Fatalf("unexpected container %q left", mc.uniqueName)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"fmt"
+
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+
+	"github.com/containerd/nri/pkg/api"
+	"github.com/golang/glog"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/cgroups"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/metrics"
+)
+
+// mutualContainer is a container that is running with the mutual cpus
+type mutualContainer struct {
+	uniqueName   string
+	podId        string
+	cgroupParent string
+	// resources are the last known resources of the container,
+	// including the mutual cpus.
+	resources *api.LinuxResources
+	// defaultPool is true when the container requested the mutual cpus
+	defaultPool bool
+	// poolCPUs are the cpus of the named pools the container requested
+	poolCPUs cpuset.CPUSet
+	// quotaPolicy is the cfs quota policy of the container
+	quotaPolicy string
+	// cfsQuota is true when the plugin manages the cfs quota of the container
+	cfsQuota bool
+	// burst is the cfs burst of the container, which is written by the plugin
+	// since the runtime does not know about it
+	burst uint64
+	// lastError is the last error of applying the shared cpus to the container
+	lastError string
+}
+
+// podQuotaUpdate is a pod-level quota change that goes along with a container update
+type podQuotaUpdate struct {
+	cgroupParent string
+	quota        cgroups.CFSQuota
+	// grow is true when the quota is raised, which means
+	// the pod's quota must be set before the container's quota.
+	grow bool
+}
+
+// UpdateMutualCPUs replaces the mutual cpus and updates all the containers
+// that are running with the mutual cpus with the new cpuset and cfs quota.
+func (p *Plugin) UpdateMutualCPUs(cpus cpuset.CPUSet) error {
+	if cpus.Size() == 0 {
+		return fmt.Errorf("there has to be at least one mutual CPU")
+	}
+
+	p.mu.Lock()
+	var oldCPUs cpuset.CPUSet
+	if p.MutualCPUs != nil {
+		oldCPUs = *p.MutualCPUs
+	}
+	p.MutualCPUs = &cpus
+	metrics.MutualCPUs.Set(float64(cpus.Size()))
+	var updates []*api.ContainerUpdate
+	podUpdates := make(map[string]*podQuotaUpdate)
+	burstUpdates := make(map[string]*pendingQuota)
+	for id, mc := range p.containers {
+		cpu := mc.resources.GetCpu()
+		if cpu == nil || !mc.defaultPool {
+			continue
+		}
+		curCpus, err := cpuset.Parse(cpu.Cpus)
+		if err != nil {
+			glog.Errorf("failed to parse container %q cpuset: %v", mc.uniqueName, err)
+			continue
+		}
+		exclusiveCpus := curCpus.Difference(oldCPUs).Difference(mc.poolCPUs)
+		sharedCPUs := p.localCPUs(cpus, exclusiveCpus).Union(mc.poolCPUs)
+		newCpus := exclusiveCpus.Union(sharedCPUs)
+		glog.Infof("container %q cpus ids %q -> %q", mc.uniqueName, cpu.Cpus, newCpus.String())
+		cpu.Cpus = newCpus.String()
+		updates = append(updates, &api.ContainerUpdate{
+			ContainerId: id,
+			Linux: &api.LinuxContainerUpdate{
+				Resources: mc.resources,
+			},
+		})
+		if !mc.cfsQuota {
+			continue
+		}
+		quota := cfsQuotaFor(mc.quotaPolicy, p.getSharedMilliCPUsLocked(), exclusiveCpus, sharedCPUs, cpu.Period.GetValue())
+		if pq, ok := p.podQuotas[mc.podId]; ok {
+			if _, ok := podUpdates[mc.podId]; !ok {
+				podUpdates[mc.podId] = &podQuotaUpdate{cgroupParent: pq.cgroupParent, quota: pq.total()}
+			}
+			for _, m := range pq.members {
+				if m.id == id {
+					m.quota = quota
+				}
+			}
+		}
+		if mc.burst != 0 || quota.Burst != 0 {
+			burstUpdates[id] = &pendingQuota{cgroupParent: mc.cgroupParent, quota: quota}
+		}
+		mc.burst = quota.Burst
+		cpu.Quota = &api.OptionalInt64{Value: quota.Quota}
+	}
+	for podId, pu := range podUpdates {
+		before := pu.quota
+		pu.quota = p.podQuotas[podId].total()
+		pu.grow = quotaGrows(before, pu.quota)
+	}
+	p.mu.Unlock()
+
+	if len(updates) == 0 {
+		return nil
+	}
+	s := p.getStub()
+	if s == nil {
+		return fmt.Errorf("no NRI stub to send container updates with")
+	}
+
+	ca := p.getCgroupsAdapter()
+	for _, pu := range podUpdates {
+		if pu.grow {
+			if err := ca.SetCFSQuota(pu.cgroupParent, pu.quota); err != nil {
+				glog.Errorf("failed to set pod %q cfs quota: %v", pu.cgroupParent, err)
+			}
+		}
+	}
+	glog.V(4).Infof("sending unsolicited updates to runtime: %+v", updates)
+	failed, err := s.UpdateContainers(updates)
+	for _, u := range updates {
+		p.setContainerError(u.GetContainerId(), nil)
+	}
+	for _, f := range failed {
+		glog.Errorf("failed to update container %q with mutual cpus %q", f.GetContainerId(), cpus.String())
+		p.setContainerError(f.GetContainerId(), fmt.Errorf("failed to update container with mutual cpus %q", cpus.String()))
+	}
+	for _, pu := range podUpdates {
+		if !pu.grow {
+			if err := ca.SetCFSQuota(pu.cgroupParent, pu.quota); err != nil {
+				glog.Errorf("failed to set pod %q cfs quota: %v", pu.cgroupParent, err)
+			}
+		}
+	}
+	// the runtime does not know about the burst
+	for id, bu := range burstUpdates {
+		if err := ca.SetContainerCFSQuota(bu.cgroupParent, id, p.getRuntime(), bu.quota); err != nil {
+			glog.Errorf("failed to set container %q cfs burst: %v", id, err)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update containers with mutual cpus %q: %w", cpus.String(), err)
+	}
+	return nil
+}
+
+// StopContainer releases the shared cpus quota of a stopped container.
+func (p *Plugin) StopContainer(pod *api.PodSandbox, ctr *api.Container) ([]*api.ContainerUpdate, error) {
+	p.releaseContainer(pod, ctr, false)
+	return nil, nil
+}
+
+// RemoveContainer releases the shared cpus quota of a removed container,
+// in case it was not stopped first.
+func (p *Plugin) RemoveContainer(pod *api.PodSandbox, ctr *api.Container) error {
+	p.releaseContainer(pod, ctr, true)
+	return nil
+}
+
+// releaseContainer drops the bookkeeping of a container that no longer runs,
+// and rolls the container's share of the pod's quota back to the quota kubelet gave it,
+// or drops its share when it is not kept for a restart.
+func (p *Plugin) releaseContainer(pod *api.PodSandbox, ctr *api.Container, removed bool) {
+	p.releaseMu.Lock()
+	defer p.releaseMu.Unlock()
+	p.undeferContainer(ctr.GetId())
+	p.untrackContainer(ctr.GetId())
+	p.deletePending(ctr.GetId())
+	quota, managed, original := p.releaseMember(pod, ctr, removed)
+	if !managed {
+		return
+	}
+	cgroupParent := pod.GetLinux().GetCgroupParent()
+	if original != nil {
+		// the container's quota must not exceed the lowered pod's quota, in case its cgroup is still around
+		if err := p.getCgroupsAdapter().SetContainerCFSQuota(cgroupParent, ctr.GetId(), p.getRuntime(), *original); err != nil {
+			glog.V(4).Infof("failed to roll back container %q cfs quota: %v", getCtrUniqueName(pod, ctr), err)
+		}
+	}
+	glog.Infof("container %q released, pod %q cfs quota %d", getCtrUniqueName(pod, ctr), cgroupParent, quota.Quota)
+	p.setPodCFSQuota(cgroupParent, quota)
+}
+
+func (p *Plugin) getMutualCPUs() cpuset.CPUSet {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.MutualCPUs == nil {
+		return cpuset.CPUSet{}
+	}
+	return *p.MutualCPUs
+}
+
+func (p *Plugin) trackContainer(ctrId string, mc *mutualContainer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.containers == nil {
+		p.containers = make(map[string]*mutualContainer)
+	}
+	p.containers[ctrId] = mc
+	metrics.SharedCPUsContainers.Set(float64(len(p.containers)))
+}
+
+// keepTrackedCFSQuota checks whether the plugin manages the cfs quota of the tracked container,
+// and sets the container's period to the tracked one when it is missing.
+// It is used for updates that leave the quota out.
+func (p *Plugin) keepTrackedCFSQuota(ctrId string, cpu *api.LinuxCPU) bool {
+	if cpu.GetQuota().GetValue() != 0 {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	mc, ok := p.containers[ctrId]
+	if !ok || !mc.cfsQuota {
+		return false
+	}
+	if cpu.GetPeriod().GetValue() == 0 {
+		period := mc.resources.GetCpu().GetPeriod().GetValue()
+		if period == 0 {
+			return false
+		}
+		cpu.Period = &api.OptionalUInt64{Value: period}
+	}
+	return true
+}
+
+// burstQuota returns the cfs quota of the container, when it has a burst
+func (p *Plugin) burstQuota(ctrId string) (cgroups.CFSQuota, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	mc, ok := p.containers[ctrId]
+	if !ok || mc.burst == 0 {
+		return cgroups.CFSQuota{}, false
+	}
+	cpu := mc.resources.GetCpu()
+	return cgroups.CFSQuota{
+		Quota:  cpu.GetQuota().GetValue(),
+		Period: cpu.GetPeriod().GetValue(),
+		Burst:  mc.burst,
+	}, true
+}
+
+func (p *Plugin) untrackContainer(ctrId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.containers, ctrId)
+	metrics.SharedCPUsContainers.Set(float64(len(p.containers)))
+}
+
+// SharedCPUs returns the mutual cpus along with the cpus of all the pools
+func (p *Plugin) SharedCPUs() cpuset.CPUSet {
+	shared := p.getMutualCPUs()
+	for _, pool := range p.Pools {
+		shared = shared.Union(pool.CPUs)
+	}
+	return shared
+}
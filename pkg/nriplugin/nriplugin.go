@@ -17,72 +17,229 @@
 package nriplugin
 
 import (
+	"context"
 	"fmt"
-	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
 	"os"
-	"strconv"
+	"sync"
+	"time"
 
 	"github.com/containerd/nri/pkg/api"
 	"github.com/containerd/nri/pkg/stub"
 	"github.com/golang/glog"
 	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/cgroups"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/config"
 	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/deviceplugin"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/health"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/metrics"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/mutualcpus"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/numa"
 )
 
 const (
 	milliCPUToCPU = 1000
+	// podResourcesTimeout bounds the queries of the devices assigned to a container
+	podResourcesTimeout = 2 * time.Second
 )
 
+// backoff for applying the cfs quota in case the cgroup writes fail
+var quotaBackoff = wait.Backoff{
+	Duration: 10 * time.Millisecond,
+	Factor:   2,
+	Steps:    5,
+}
+
+// cgroupsAdapter is the subset of the cgroups adapter used for applying the cfs quota
+type cgroupsAdapter interface {
+	SetCFSQuota(processCgroupPath string, quota cgroups.CFSQuota) error
+	SetContainerCFSQuota(parentPath, ctrId string, runtime cgroups.Runtime, quota cgroups.CFSQuota) error
+}
+
+// pendingQuota is a cfs quota that should be applied to
+// a created container and to its pod's cgroup
+type pendingQuota struct {
+	podId        string
+	cgroupParent string
+	quota        cgroups.CFSQuota
+}
+
 // Plugin nriplugin for mixed cpus
 type Plugin struct {
 	Stub       stub.Stub
 	MutualCPUs *cpuset.CPUSet
+	// Pools are the named shared pools.
+	// Their cpus are added to containers that requested them.
+	Pools []deviceplugin.Pool
+	// Runtime determines the containers' cgroups layout.
+	// When not set, it is detected from the runtime the plugin is registered to.
+	Runtime cgroups.Runtime
+	// Topology enables NUMA awareness when set.
+	// Containers get only the shared cpus that are on the NUMA nodes of their exclusive cpus.
+	Topology numa.Topology
+	// Devices gets back the devices of removed pods, when set
+	Devices DeviceReleaser
+	// Config is the plugin's configuration, when given
+	Config *config.Config
+	// PodResources reports the devices kubelet assigned to the containers that requested the shared cpus, when set.
+	// Otherwise, the devices are taken from the containers' environment variables.
+	PodResources PodResourcesLister
+
+	// cgroups defaults to the global cgroups.Adapter
+	cgroups cgroupsAdapter
+	// stubOpts are used for creating a stub on every connection to the runtime
+	stubOpts []stub.Option
+	// mu protects Stub, MutualCPUs, Runtime, Config and the containers' bookkeeping
+	mu sync.Mutex
+	// pending maps container ids to quotas that were not applied yet
+	pending map[string]*pendingQuota
+	// containers maps container ids to the containers that are running with mutual cpus
+	containers map[string]*mutualContainer
+	// deferred maps the ids of started containers that wait for their shared cpus to their pod ids
+	deferred map[string]string
+	// releaseMu serializes releasing containers with adding the deferred shared cpus,
+	// and is taken before mu
+	releaseMu sync.Mutex
+	// podQuotas maps pod ids to the cfs quotas of the pods' containers
+	podQuotas map[string]*podQuota
+	// podDevices maps pod ids to the device ids of each resource allocated to the pod
+	podDevices map[string]map[string]map[string]bool
+	// mutualCPUsHandlers are called when the runtime configuration changes the mutual cpus
+	mutualCPUsHandlers []mutualcpus.Handler
+	// formerMutualCPUs are the cpus removed from the mutual cpus by the runtime configuration
+	formerMutualCPUs cpuset.CPUSet
 }
 
 type Args struct {
-	PluginName string
-	PluginIdx  string
-	MutualCPUs string
+	PluginName  string
+	PluginIdx   string
+	MutualCPUs  string
+	SharedPools []string
+	Runtime     string
+	NUMAAware   bool
 }
 
 func New(args *Args) (*Plugin, error) {
 	p := &Plugin{}
-	var opts []stub.Option
 
 	if args.PluginName != "" {
-		opts = append(opts, stub.WithPluginName(args.PluginName))
+		p.stubOpts = append(p.stubOpts, stub.WithPluginName(args.PluginName))
 	}
 	if args.PluginIdx != "" {
-		opts = append(opts, stub.WithPluginIdx(args.PluginIdx))
+		p.stubOpts = append(p.stubOpts, stub.WithPluginIdx(args.PluginIdx))
 	}
 	c, err := cpuset.Parse(args.MutualCPUs)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse cpuset %q: %w", args.MutualCPUs, err)
 	}
-	if c.Size() == 0 {
+	if p.Pools, err = deviceplugin.ParsePools(args.SharedPools); err != nil {
+		return nil, err
+	}
+	if c.Size() == 0 && len(p.Pools) == 0 {
 		return p, fmt.Errorf("there has to be at least one mutual CPU")
 	}
 	glog.Infof("node %q mutual CPUs: %q", os.ExpandEnv("$NODE_NAME"), c.String())
+	for _, pool := range p.Pools {
+		glog.Infof("node %q shared pool %q CPUs: %q", os.ExpandEnv("$NODE_NAME"), pool.Name, pool.CPUs.String())
+	}
 	p.MutualCPUs = &c
+	metrics.MutualCPUs.Set(float64(c.Size()))
+
+	if args.Runtime != "" {
+		if p.Runtime, err = cgroups.ParseRuntime(args.Runtime); err != nil {
+			return nil, err
+		}
+	}
+
+	if args.NUMAAware {
+		if p.Topology, err = numa.Discover(numa.SysfsRoot); err != nil {
+			return nil, err
+		}
+		for id, cpus := range p.Topology {
+			glog.Infof("node %q NUMA node %d shared CPUs: %q", os.ExpandEnv("$NODE_NAME"), id, cpus.Intersection(c).String())
+		}
+	}
 
-	if p.Stub, err = stub.New(p, opts...); err != nil {
-		return nil, fmt.Errorf("failed to create plugin stub: %w", err)
+	if p.Stub, err = p.newStub(); err != nil {
+		return nil, err
 	}
 	return p, nil
 }
 
+// Configure applies the configuration given by the runtime, if any,
+// and detects the container runtime, unless it was set explicitly.
+// The plugin is ready once it is configured by the runtime.
+func (p *Plugin) Configure(cfg, runtime, version string) (api.EventMask, error) {
+	glog.Infof("connected to runtime %s/%s", runtime, version)
+	if cfg != "" {
+		if err := p.applyRuntimeConfig(cfg); err != nil {
+			return 0, fmt.Errorf("Configure: invalid runtime configuration: %w", err)
+		}
+	}
+	if p.getRuntime() == "" {
+		rt, err := cgroups.ParseRuntime(runtime)
+		if err != nil {
+			return 0, fmt.Errorf("Configure: %w", err)
+		}
+		p.mu.Lock()
+		p.Runtime = rt
+		p.mu.Unlock()
+		glog.Infof("detected container runtime %q", rt)
+	}
+	health.SetReady(health.NRI, true)
+	return 0, nil
+}
+
 // CreateContainer handles container creation requests.
 func (p *Plugin) CreateContainer(pod *api.PodSandbox, ctr *api.Container) (*api.ContainerAdjustment, []*api.ContainerUpdate, error) {
+	defer health.Begin("CreateContainer")()
+	adjustment, updates, err := p.createContainer(pod, ctr)
+	metrics.NRIRequests.WithLabelValues("CreateContainer", metrics.Result(err)).Inc()
+	return adjustment, updates, err
+}
+
+func (p *Plugin) createContainer(pod *api.PodSandbox, ctr *api.Container) (*api.ContainerAdjustment, []*api.ContainerUpdate, error) {
 	adjustment := &api.ContainerAdjustment{}
 	updates := []*api.ContainerUpdate{}
 
-	if !deviceplugin.Requested(ctr) {
+	pools, devices := p.requestedPools(pod, ctr)
+	if len(pools) == 0 {
+		// the pod's quota accounts for all of its containers
+		quota := ctrCFSQuota(ctr.GetLinux().GetResources())
+		before, after, managed := p.setMemberQuota(pod, ctr, quota, &quota, false)
+		if managed && quotaGrows(before, after) {
+			p.setPodCFSQuota(pod.GetLinux().GetCgroupParent(), after)
+		}
 		return adjustment, updates, nil
 	}
+	p.trackDevices(pod, devices)
 	uniqueName := getCtrUniqueName(pod, ctr)
+	exclusiveCPUs, err := getCtrCPUs(ctr)
+	if err != nil {
+		return adjustment, updates, fmt.Errorf("CreateContainer: setMutualCPUs failed: %w", err)
+	}
+	pools = p.localPools(pools, exclusiveCPUs, uniqueName)
+	sharedCPUs, defaultPool, poolCPUs := sharedCPUsOf(pools)
+	deferred, err := p.deferSharedCPUs(pod, ctr)
+	if err != nil {
+		return adjustment, updates, fmt.Errorf("CreateContainer: %w", err)
+	}
+	for _, pool := range pools {
+		// the values handed out by the device plugin are not NUMA aware,
+		// and containers that requested the pool by annotation have none
+		if _, ok := devices[pool.ResourceName()]; !ok || p.Topology != nil {
+			adjustment.AddEnv(pool.EnvVarName(), pool.CPUs.String())
+		}
+	}
+	if deferred {
+		// the container is created with its exclusive cpus, so the pod's quota accounts for them only
+		quota := ctrCFSQuota(ctr.GetLinux().GetResources())
+		p.setMemberQuota(pod, ctr, quota, &quota, false)
+		glog.V(4).Infof("sending adjustment to runtime: %+v", adjustment)
+		return adjustment, updates, nil
+	}
 	glog.Infof("append mutual cpus to container %q", uniqueName)
-	err := setMutualCPUs(ctr, p.MutualCPUs, uniqueName)
+	err = setMutualCPUs(ctr, &sharedCPUs, uniqueName)
 	if err != nil {
 		return adjustment, updates, fmt.Errorf("CreateContainer: setMutualCPUs failed: %w", err)
 	}
@@ -93,45 +250,91 @@ func (p *Plugin) CreateContainer(pod *api.PodSandbox, ctr *api.Container) (*api.
 	//oversteps their boundaries, or the threads that are running
 	//under the reserved cpus consumes the cpuQuota (pretty common in dpdk/latency sensitive applications).
 	//Since we can't determine the cpuQuota for the mutual cpus
-	//and avoid throttling the process is critical, increasing the cpuQuota to the maximum is the best option.
-	quota, err := calculateCFSQuota(ctr)
-	if err != nil {
-		return adjustment, updates, fmt.Errorf("failed to calculate CFS quota: %w", err)
-	}
-
-	parentCfsQuotaPath, err := cgroups.Adapter.GetCFSQuotaPath(pod.GetLinux().GetCgroupParent())
-	if err != nil {
-		return adjustment, updates, fmt.Errorf("failed to find parent cfs quota: %w", err)
-	}
-
-	ctrCfsQuotaPath, err := cgroups.Adapter.GetCrioContainerCFSQuotaPath(pod.GetLinux().GetCgroupParent(), ctr.GetId())
-	if err != nil {
-		return adjustment, updates, fmt.Errorf("failed to find parent cfs quota: %w", err)
-	}
-
-	glog.Infof("inject hook to modify container's cgroups %q quota to: %d", ctrCfsQuotaPath, quota)
-	hook := &api.Hook{
-		Path: "/bin/bash",
-		Args: []string{
-			"/bin/bash",
-			"-c",
-			fmt.Sprintf("echo %d > %s && echo %d > %s", quota, parentCfsQuotaPath, quota, ctrCfsQuotaPath),
-		},
-	}
-	adjustment.Hooks = &api.Hooks{
-		CreateRuntime: []*api.Hook{hook},
+	//and avoid throttling the process is critical, the quota policy decides how much of them the container gets.
+	quotaPolicy := p.quotaPolicy(pod)
+	original := ctrCFSQuota(ctr.GetLinux().GetResources())
+	var ctrQuota cgroups.CFSQuota
+	cfsQuota := cfsQuotaEnabled(pod, ctr.Linux.Resources.Cpu)
+	if cfsQuota {
+		ctrQuota = cfsQuotaFor(quotaPolicy, p.getSharedMilliCPUs(), exclusiveCPUs, sharedCPUs, ctr.Linux.Resources.Cpu.Period.GetValue())
+		// the quota is applied once the container's cgroup exists.
+		// the pod's cgroup quota must be raised first, otherwise
+		// the container's quota cannot exceed it.
+		p.setMemberQuota(pod, ctr, ctrQuota, &original, true)
+		p.setPending(ctr.GetId(), &pendingQuota{
+			podId:        pod.GetId(),
+			cgroupParent: pod.GetLinux().GetCgroupParent(),
+			quota:        ctrQuota,
+		})
+	} else {
+		glog.Infof("container %q has no cfs quota to manage, leaving it untouched", uniqueName)
+		p.setMemberQuota(pod, ctr, original, &original, false)
 	}
 	adjustment.Linux = &api.LinuxContainerAdjustment{
 		Resources: ctr.Linux.GetResources(),
 	}
+	p.trackContainer(ctr.GetId(), &mutualContainer{
+		uniqueName:   uniqueName,
+		podId:        pod.GetId(),
+		cgroupParent: pod.GetLinux().GetCgroupParent(),
+		resources:    ctr.Linux.GetResources(),
+		defaultPool:  defaultPool,
+		poolCPUs:     poolCPUs,
+		quotaPolicy:  quotaPolicy,
+		cfsQuota:     cfsQuota,
+		burst:        ctrQuota.Burst,
+	})
 
 	glog.V(4).Infof("sending adjustment to runtime: %+v", adjustment)
 	return adjustment, updates, nil
 }
 
+// PostCreateContainer applies the cfs quota of a container that was created with mutual cpus.
+func (p *Plugin) PostCreateContainer(pod *api.PodSandbox, ctr *api.Container) error {
+	pq := p.getPending(ctr.GetId())
+	if pq == nil {
+		return nil
+	}
+	if err := p.applyQuota(ctr.GetId(), pq); err != nil {
+		// keep the quota pending, so it would be retried on StartContainer
+		glog.Errorf("PostCreateContainer: container %q: %v", getCtrUniqueName(pod, ctr), err)
+		p.setContainerError(ctr.GetId(), err)
+		return fmt.Errorf("PostCreateContainer: %w", err)
+	}
+	p.deletePending(ctr.GetId())
+	p.setContainerError(ctr.GetId(), nil)
+	return nil
+}
+
+// StartContainer retries applying the cfs quota in case it failed after the container's creation.
+func (p *Plugin) StartContainer(pod *api.PodSandbox, ctr *api.Container) error {
+	pq := p.getPending(ctr.GetId())
+	if pq == nil {
+		return nil
+	}
+	// this is the last attempt, so no need to keep the quota anymore
+	p.deletePending(ctr.GetId())
+	if err := p.applyQuota(ctr.GetId(), pq); err != nil {
+		glog.Errorf("StartContainer: container %q: %v", getCtrUniqueName(pod, ctr), err)
+		p.setContainerError(ctr.GetId(), err)
+		return fmt.Errorf("StartContainer: %w", err)
+	}
+	p.setContainerError(ctr.GetId(), nil)
+	return nil
+}
+
+// UpdateContainer keeps the shared cpus of containers that are updated, e.g. by CPU Manager.
 func (p *Plugin) UpdateContainer(pod *api.PodSandbox, ctr *api.Container) ([]*api.ContainerUpdate, error) {
+	defer health.Begin("UpdateContainer")()
+	updates, err := p.updateContainer(pod, ctr)
+	metrics.NRIRequests.WithLabelValues("UpdateContainer", metrics.Result(err)).Inc()
+	return updates, err
+}
+
+func (p *Plugin) updateContainer(pod *api.PodSandbox, ctr *api.Container) ([]*api.ContainerUpdate, error) {
 	updates := []*api.ContainerUpdate{}
-	if !deviceplugin.Requested(ctr) {
+	pools, devices := p.requestedPools(pod, ctr)
+	if len(pools) == 0 {
 		// A hack in order to keep CRI-O from crashing
 		// issue: https://github.com/cri-o/cri-o/issues/6642
 		updates = append(updates, &api.ContainerUpdate{
@@ -140,67 +343,319 @@ func (p *Plugin) UpdateContainer(pod *api.PodSandbox, ctr *api.Container) ([]*ap
 				Resources: ctr.Linux.Resources,
 			},
 		})
+		// updates that leave the quota out, e.g. of the CPU Manager, keep it
+		if ctr.GetLinux().GetResources().GetCpu().GetQuota().GetValue() != 0 {
+			quota := ctrCFSQuota(ctr.GetLinux().GetResources())
+			p.growPodQuota(pod, ctr, quota, &quota, false)
+		}
 		return updates, nil
 	}
 
 	glog.Infof("updating container %s/%s/%s...", pod.GetNamespace(), pod.GetName(), ctr.GetName())
-	curCpus, err := cpuset.Parse(ctr.Linux.Resources.Cpu.Cpus)
+	p.trackDevices(pod, devices)
+	// the quota kubelet asked for, e.g. on an in-place resize, is the container's original quota
+	var original *cgroups.CFSQuota
+	if ctr.GetLinux().GetResources().GetCpu().GetQuota().GetValue() != 0 {
+		q := ctrCFSQuota(ctr.GetLinux().GetResources())
+		original = &q
+	}
+	update, quota, err := p.sharedCPUsUpdate(pod, ctr, pools)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse container %q cpuset %w", ctr.Id, err)
+		return nil, err
 	}
-	// bypass updates coming from CPUManager
-	ctr.Linux.Resources.Cpu.Cpus = curCpus.Union(*p.MutualCPUs).String()
-	quota, err := calculateCFSQuota(ctr)
+	if quota != nil {
+		p.growPodQuota(pod, ctr, *quota, original, true)
+	}
+	updates = append(updates, update)
+	glog.V(4).Infof("sending update to runtime: %+v", updates)
+	return updates, nil
+}
+
+// growPodQuota records the updated quota of the container, e.g. after a CPU Manager update or an in-place resize.
+// A raised pod quota is written right away, since the runtime updates the container once we return;
+// a lowered one is written by PostUpdateContainer.
+func (p *Plugin) growPodQuota(pod *api.PodSandbox, ctr *api.Container, quota cgroups.CFSQuota, original *cgroups.CFSQuota, shared bool) {
+	before, after, managed := p.setMemberQuota(pod, ctr, quota, original, shared)
+	if managed && quotaGrows(before, after) {
+		p.setPodCFSQuota(pod.GetLinux().GetCgroupParent(), after)
+	}
+}
+
+// PostUpdateContainer writes the pod's quota once the container was updated, so a lowered quota takes effect.
+// The burst of the container is written as well, since the runtime does not know about it.
+func (p *Plugin) PostUpdateContainer(pod *api.PodSandbox, ctr *api.Container) error {
+	if quota, managed := p.podCFSQuota(pod.GetId()); managed {
+		p.setPodCFSQuota(pod.GetLinux().GetCgroupParent(), quota)
+	}
+	if quota, ok := p.burstQuota(ctr.GetId()); ok {
+		if err := p.getCgroupsAdapter().SetContainerCFSQuota(pod.GetLinux().GetCgroupParent(), ctr.GetId(), p.getRuntime(), quota); err != nil {
+			glog.Errorf("failed to set container %q cfs burst: %v", getCtrUniqueName(pod, ctr), err)
+		}
+	}
+	return nil
+}
+
+// sharedCPUsUpdate returns an update that adds the pools' cpus
+// to the container's cpus and sets its cfs quota according to the quota policy, along with the quota.
+// The quota is nil when the container's cfs quota is not managed by the plugin.
+// The container is tracked, so it would be updated on mutual cpus changes.
+func (p *Plugin) sharedCPUsUpdate(pod *api.PodSandbox, ctr *api.Container, pools []deviceplugin.Pool) (*api.ContainerUpdate, *cgroups.CFSQuota, error) {
+	cpu := ctr.GetLinux().GetResources().GetCpu()
+	if cpu == nil {
+		return nil, nil, fmt.Errorf("no cpu resources found for container %q", ctr.GetName())
+	}
+	curCpus, err := cpuset.Parse(cpu.Cpus)
 	if err != nil {
-		return nil, fmt.Errorf("failed to calculate CFS quota: %w", err)
+		return nil, nil, fmt.Errorf("failed to parse container %q cpuset %w", ctr.Id, err)
+	}
+	// the container may be running with shared cpus already
+	allSharedCPUs, _, _ := sharedCPUsOf(pools)
+	exclusiveCpus := curCpus.Difference(allSharedCPUs).Difference(p.getFormerMutualCPUs())
+	sharedCPUs, defaultPool, poolCPUs := sharedCPUsOf(p.localPools(pools, exclusiveCpus, getCtrUniqueName(pod, ctr)))
+	// bypass updates coming from CPUManager
+	cpu.Cpus = exclusiveCpus.Union(sharedCPUs).String()
+	quotaPolicy := p.quotaPolicy(pod)
+	var quota *cgroups.CFSQuota
+	var burst uint64
+	// updates that leave the quota out, e.g. of the CPU Manager, keep the quota managed
+	cfsQuota := cfsQuotaEnabled(pod, cpu) || (!crioCFSQuotaDisabled(pod) && p.keepTrackedCFSQuota(ctr.GetId(), cpu))
+	if cfsQuota {
+		q := cfsQuotaFor(quotaPolicy, p.getSharedMilliCPUs(), exclusiveCpus, sharedCPUs, cpu.Period.GetValue())
+		cpu.Quota = &api.OptionalInt64{Value: q.Quota}
+		quota, burst = &q, q.Burst
 	}
-	ctr.Linux.Resources.Cpu.Quota.Value = quota
+	p.trackContainer(ctr.GetId(), &mutualContainer{
+		uniqueName:   getCtrUniqueName(pod, ctr),
+		podId:        pod.GetId(),
+		cgroupParent: pod.GetLinux().GetCgroupParent(),
+		resources:    ctr.Linux.Resources,
+		defaultPool:  defaultPool,
+		poolCPUs:     poolCPUs,
+		quotaPolicy:  quotaPolicy,
+		cfsQuota:     cfsQuota,
+		burst:        burst,
+	})
 
-	res := &api.ContainerUpdate{
+	return &api.ContainerUpdate{
 		ContainerId: ctr.Id,
 		Linux: &api.LinuxContainerUpdate{
 			Resources: ctr.Linux.Resources,
 		},
+	}, quota, nil
+}
+
+// applyQuota writes the quota into the pod's cgroup and then into the container's cgroup.
+// failed writes are retried with a backoff.
+func (p *Plugin) applyQuota(ctrId string, pq *pendingQuota) error {
+	defer health.Begin("ApplyQuota")()
+	ca := p.getCgroupsAdapter()
+	var lastErr error
+	err := wait.ExponentialBackoff(quotaBackoff, func() (bool, error) {
+		// the pod's quota is the sum of all of its containers' quotas
+		podQuota, managed := p.podCFSQuota(pq.podId)
+		if !managed {
+			podQuota = pq.quota
+		}
+		if lastErr = ca.SetCFSQuota(pq.cgroupParent, podQuota); lastErr != nil {
+			lastErr = fmt.Errorf("failed to set pod cfs quota: %w", lastErr)
+			glog.V(4).Infof("%v; retrying", lastErr)
+			return false, nil
+		}
+		if lastErr = ca.SetContainerCFSQuota(pq.cgroupParent, ctrId, p.getRuntime(), pq.quota); lastErr != nil {
+			lastErr = fmt.Errorf("failed to set container cfs quota: %w", lastErr)
+			glog.V(4).Infof("%v; retrying", lastErr)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		metrics.CFSQuotaUpdates.WithLabelValues(metrics.ResultError).Inc()
+		return lastErr
 	}
-	updates = append(updates, res)
-	glog.V(4).Infof("sending update to runtime: %+v", updates)
-	return updates, nil
+	metrics.CFSQuotaUpdates.WithLabelValues(metrics.ResultSuccess).Inc()
+	glog.Infof("container %q cgroups quota set to: %d", ctrId, pq.quota.Quota)
+	return nil
 }
 
-func setMutualCPUs(ctr *api.Container, mutualCPUs *cpuset.CPUSet, uniqueName string) error {
+func (p *Plugin) getCgroupsAdapter() cgroupsAdapter {
+	if p.cgroups == nil {
+		return burstFallbackAdapter{&cgroups.Adapter}
+	}
+	return burstFallbackAdapter{p.cgroups}
+}
+
+func (p *Plugin) setPending(ctrId string, pq *pendingQuota) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending == nil {
+		p.pending = make(map[string]*pendingQuota)
+	}
+	p.pending[ctrId] = pq
+}
+
+func (p *Plugin) getPending(ctrId string) *pendingQuota {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pending[ctrId]
+}
+
+func (p *Plugin) deletePending(ctrId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending, ctrId)
+}
+
+// requestedPools returns the pools the container requested, along with
+// the ids of the devices allocated from each pool, keyed by the pool's resource name.
+// The mutual cpus are returned as the unnamed pool.
+// Pools that were requested only by the pod's annotations have no devices entry,
+// and they are given only to containers with exclusive cpus.
+func (p *Plugin) requestedPools(pod *api.PodSandbox, ctr *api.Container) ([]deviceplugin.Pool, map[string][]string) {
+	all := append([]deviceplugin.Pool{{CPUs: p.getMutualCPUs()}}, p.Pools...)
+	policy := p.optInPolicy()
+	// kubelet is asked only about containers that were allocated any of the devices
+	var assigned map[string][]string
+	queried := false
+
+	var pools []deviceplugin.Pool
+	annotated := false
+	devices := make(map[string][]string)
+	for _, pool := range all {
+		if policy != config.OptInAnnotation && deviceplugin.RequestedPool(ctr, pool.EnvVarName()) {
+			if !queried {
+				assigned = p.assignedDevices(pod, ctr)
+				queried = true
+			}
+			pools = append(pools, pool)
+			devices[pool.ResourceName()] = requestedDevices(ctr, pool, assigned)
+			continue
+		}
+		if policy != config.OptInDevice && annotatedPool(pod, ctr, pool) {
+			pools = append(pools, pool)
+			annotated = true
+		}
+	}
+	if !annotated {
+		return pools, devices
+	}
+	sharedCPUs := p.getFormerMutualCPUs()
+	for _, pool := range all {
+		sharedCPUs = sharedCPUs.Union(pool.CPUs)
+	}
+	if !exclusiveCPUs(pod, ctr, sharedCPUs) {
+		glog.Warningf("container %q is annotated for the shared cpus but has no exclusive cpus; ignoring the annotation",
+			getCtrUniqueName(pod, ctr))
+		var requested []deviceplugin.Pool
+		for _, pool := range pools {
+			if _, ok := devices[pool.ResourceName()]; ok {
+				requested = append(requested, pool)
+			}
+		}
+		return requested, devices
+	}
+	return pools, devices
+}
+
+// requestedDevices returns the ids of the pool's devices that were allocated to the container.
+// The devices kubelet assigned are used when it reports them, otherwise the environment variables are,
+// e.g. when kubelet does not know about the container's devices yet.
+func requestedDevices(ctr *api.Container, pool deviceplugin.Pool, assigned map[string][]string) []string {
+	if ids := assigned[pool.DeviceName()]; len(ids) > 0 {
+		return ids
+	}
+	return deviceplugin.DeviceIDs(ctr, pool)
+}
+
+// assignedDevices returns the devices kubelet assigned to the container.
+// A nil map means kubelet could not tell, so the environment variables should be used.
+// The query is bounded by a short timeout, since it holds up the runtime.
+func (p *Plugin) assignedDevices(pod *api.PodSandbox, ctr *api.Container) map[string][]string {
+	if p.PodResources == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), podResourcesTimeout)
+	defer cancel()
+	devices, err := p.PodResources.ContainerDevices(ctx, pod.GetNamespace(), pod.GetName(), ctr.GetName())
+	if err != nil {
+		glog.Warningf("failed to get container %q devices, falling back to environment variables: %v", getCtrUniqueName(pod, ctr), err)
+		return nil
+	}
+	return devices
+}
+
+// localPools narrows down the pools' cpus to the ones
+// that are local to the exclusive cpus, when NUMA awareness is enabled
+func (p *Plugin) localPools(pools []deviceplugin.Pool, exclusiveCPUs cpuset.CPUSet, uniqueName string) []deviceplugin.Pool {
+	if p.Topology == nil {
+		return pools
+	}
+	var res []deviceplugin.Pool
+	for _, pool := range pools {
+		local := p.localCPUs(pool.CPUs, exclusiveCPUs)
+		glog.V(4).Infof("container %q pool %q local cpus %q out of %q", uniqueName, pool.Name, local.String(), pool.CPUs.String())
+		res = append(res, deviceplugin.Pool{Name: pool.Name, CPUs: local})
+	}
+	return res
+}
+
+// localCPUs returns the shared cpus that are on the NUMA nodes of the exclusive cpus.
+// When none of the shared cpus is local, all of them are returned
+// so the container would not end up without shared cpus.
+func (p *Plugin) localCPUs(sharedCPUs, exclusiveCPUs cpuset.CPUSet) cpuset.CPUSet {
+	if p.Topology == nil {
+		return sharedCPUs
+	}
+	local := p.Topology.LocalCPUs(sharedCPUs, exclusiveCPUs)
+	if local.IsEmpty() {
+		glog.Warningf("no shared cpus out of %q are local to NUMA nodes %v; using all of them",
+			sharedCPUs.String(), p.Topology.NodesOf(exclusiveCPUs))
+		return sharedCPUs
+	}
+	return local
+}
+
+// sharedCPUsOf returns the shared cpus of all the pools.
+// It also returns whether the mutual cpus are part of the pools,
+// and the cpus of the named pools.
+func sharedCPUsOf(pools []deviceplugin.Pool) (sharedCPUs cpuset.CPUSet, defaultPool bool, poolCPUs cpuset.CPUSet) {
+	sharedCPUs = cpuset.New()
+	poolCPUs = cpuset.New()
+	for _, pool := range pools {
+		sharedCPUs = sharedCPUs.Union(pool.CPUs)
+		if pool.Name == "" {
+			defaultPool = true
+			continue
+		}
+		poolCPUs = poolCPUs.Union(pool.CPUs)
+	}
+	return
+}
+
+// getCtrCPUs returns the cpus the container was assigned with
+func getCtrCPUs(ctr *api.Container) (cpuset.CPUSet, error) {
 	lspec := ctr.GetLinux()
 	if lspec == nil ||
 		lspec.Resources == nil ||
 		lspec.Resources.Cpu == nil ||
 		lspec.Resources.Cpu.Cpus == "" {
-		return fmt.Errorf("no cpus found for container %q", ctr.GetName())
+		return cpuset.CPUSet{}, fmt.Errorf("no cpus found for container %q", ctr.GetName())
 	}
-	ctrCpus := lspec.Resources.Cpu
-	curCpus, err := cpuset.Parse(ctrCpus.Cpus)
+	return cpuset.Parse(lspec.Resources.Cpu.Cpus)
+}
+
+func setMutualCPUs(ctr *api.Container, mutualCPUs *cpuset.CPUSet, uniqueName string) error {
+	curCpus, err := getCtrCPUs(ctr)
 	glog.V(4).Infof("container %q cpus ids before applying mutual cpus %q", uniqueName, curCpus.String())
 	if err != nil {
 		return err
 	}
+	ctrCpus := ctr.Linux.Resources.Cpu
 
 	ctrCpus.Cpus = curCpus.Union(*mutualCPUs).String()
 	glog.V(4).Infof("container %q cpus ids after applying mutual cpus %q", uniqueName, ctrCpus.Cpus)
 	return nil
 }
 
-func calculateCFSQuota(ctr *api.Container) (quota int64, err error) {
-	lspec := ctr.Linux
-	cpus, err := cpuset.Parse(lspec.Resources.Cpu.Cpus)
-	if err != nil {
-		return
-	}
-	quan, err := resource.ParseQuantity(strconv.Itoa(cpus.Size()))
-	if err != nil {
-		return
-	}
-	quota = (quan.MilliValue() * int64(lspec.Resources.Cpu.Period.Value)) / milliCPUToCPU
-	return
-}
-
 func getCtrUniqueName(pod *api.PodSandbox, ctr *api.Container) string {
 	return fmt.Sprintf("%s/%s/%s", pod.GetNamespace(), pod.GetName(), ctr.GetName())
 }
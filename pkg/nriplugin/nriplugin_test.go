@@ -17,14 +17,20 @@
 package nriplugin
 
 import (
+	"context"
 	"fmt"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 
 	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
 
 	"github.com/containerd/nri/pkg/api"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/cgroups"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/deviceplugin"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/numa"
 	e2ecpuset "github.com/openshift-kni/mixed-cpu-node-plugin/test/e2e/cpuset"
 )
 
@@ -36,6 +42,7 @@ func TestCreateContainer(t *testing.T) {
 	testCases := []struct {
 		name       string
 		mutualCPUs cpuset.CPUSet
+		pools      []deviceplugin.Pool
 		sb         *api.PodSandbox
 		ctr        *api.Container
 		lres       *api.LinuxResources
@@ -51,6 +58,47 @@ func TestCreateContainer(t *testing.T) {
 			quota:      20000,
 			cpuset:     "1,2",
 		},
+		{
+			name:       "container requesting mutual cpus",
+			mutualCPUs: e2ecpuset.MustParse(sampleCPUs),
+			sb:         makePodSandbox("test-sb"),
+			ctr: makeContainer("test-ctr",
+				withLinuxResources("1,2", 20000),
+				withPeriod(100000),
+				withEnv(deviceplugin.EnvVarName+"="+sampleCPUs)),
+			lres:   &api.LinuxResources{},
+			quota:  20000,
+			cpuset: "0-2,5,7-10",
+		},
+		{
+			name:       "container requesting a named pool",
+			mutualCPUs: e2ecpuset.MustParse(sampleCPUs),
+			pools:      []deviceplugin.Pool{{Name: "io", CPUs: e2ecpuset.MustParse("3-4")}},
+			sb:         makePodSandbox("test-sb"),
+			ctr: makeContainer("test-ctr",
+				withLinuxResources("1,2", 20000),
+				withPeriod(100000),
+				withEnv(deviceplugin.PoolEnvVarName("io")+"=3-4")),
+			lres:   &api.LinuxResources{},
+			quota:  20000,
+			cpuset: "1-4",
+		},
+		{
+			name:       "container requesting mutual cpus and a named pool",
+			mutualCPUs: e2ecpuset.MustParse(sampleCPUs),
+			pools: []deviceplugin.Pool{
+				{Name: "io", CPUs: e2ecpuset.MustParse("3-4")},
+				{Name: "mgmt", CPUs: e2ecpuset.MustParse("6")},
+			},
+			sb: makePodSandbox("test-sb"),
+			ctr: makeContainer("test-ctr",
+				withLinuxResources("1,2", 20000),
+				withPeriod(100000),
+				withEnv(deviceplugin.EnvVarName+"="+sampleCPUs, deviceplugin.PoolEnvVarName("mgmt")+"=6")),
+			lres:   &api.LinuxResources{},
+			quota:  20000,
+			cpuset: "0-2,5-10",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -58,6 +106,7 @@ func TestCreateContainer(t *testing.T) {
 			p := &Plugin{
 				Stub:       nil,
 				MutualCPUs: &tc.mutualCPUs,
+				Pools:      tc.pools,
 			}
 			ca, _, err := p.CreateContainer(tc.sb, tc.ctr)
 			if err != nil {
@@ -80,6 +129,429 @@ func TestCreateContainer(t *testing.T) {
 	}
 }
 
+func TestCreateContainerNUMAAware(t *testing.T) {
+	topo := numa.Topology{
+		0: e2ecpuset.MustParse("0-3"),
+		1: e2ecpuset.MustParse("4-7"),
+	}
+	testCases := []struct {
+		name    string
+		cpus    string
+		env     []string
+		cpuset  string
+		wantEnv map[string]string
+	}{
+		{
+			name:    "exclusive cpus on node0",
+			cpus:    "2-3",
+			env:     []string{deviceplugin.EnvVarName + "=0,4"},
+			cpuset:  "0,2-3",
+			wantEnv: map[string]string{deviceplugin.EnvVarName: "0"},
+		},
+		{
+			name:    "exclusive cpus on node1 with a named pool",
+			cpus:    "6",
+			env:     []string{deviceplugin.EnvVarName + "=0,4", deviceplugin.PoolEnvVarName("io") + "=1,5"},
+			cpuset:  "4-6",
+			wantEnv: map[string]string{deviceplugin.EnvVarName: "4", deviceplugin.PoolEnvVarName("io"): "5"},
+		},
+		{
+			name:    "exclusive cpus across nodes",
+			cpus:    "3-4",
+			env:     []string{deviceplugin.EnvVarName + "=0,4"},
+			cpuset:  "0,3-4",
+			wantEnv: map[string]string{deviceplugin.EnvVarName: "0,4"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mutualCPUs := e2ecpuset.MustParse("0,4")
+			p := &Plugin{
+				MutualCPUs: &mutualCPUs,
+				Pools:      []deviceplugin.Pool{{Name: "io", CPUs: e2ecpuset.MustParse("1,5")}},
+				Topology:   topo,
+			}
+			ctr := makeContainer("test-ctr",
+				withLinuxResources(tc.cpus, 20000),
+				withPeriod(100000),
+				withEnv(tc.env...))
+			ca, _, err := p.CreateContainer(makePodSandbox("test-sb"), ctr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ca.Linux.Resources.Cpu.Cpus; got != tc.cpuset {
+				t.Fatalf("unexpected cpuset; want: %q, got: %q", tc.cpuset, got)
+			}
+			gotEnv := make(map[string]string)
+			for _, kv := range ca.Env {
+				gotEnv[kv.Key] = kv.Value
+			}
+			if !reflect.DeepEqual(gotEnv, tc.wantEnv) {
+				t.Fatalf("unexpected env; want: %v, got: %v", tc.wantEnv, gotEnv)
+			}
+		})
+	}
+}
+
+func TestApplyQuota(t *testing.T) {
+	testCases := []struct {
+		name string
+		// number of failed writes before the fake cgroups adapter succeeds
+		failures      int
+		postCreateErr bool
+		startErr      bool
+		wantPodQuota  int64
+		wantCtrQuota  int64
+		wantPeriod    uint64
+	}{
+		{
+			name:         "quota applied on post create",
+			wantPodQuota: 800000,
+			wantCtrQuota: 800000,
+			wantPeriod:   100000,
+		},
+		{
+			name:         "quota applied after transient failures",
+			failures:     2,
+			wantPodQuota: 800000,
+			wantCtrQuota: 800000,
+			wantPeriod:   100000,
+		},
+		{
+			name:          "quota applied on start container retry",
+			failures:      quotaBackoff.Steps,
+			postCreateErr: true,
+			wantPodQuota:  800000,
+			wantCtrQuota:  800000,
+			wantPeriod:    100000,
+		},
+		{
+			name:          "quota cannot be applied",
+			failures:      2 * quotaBackoff.Steps,
+			postCreateErr: true,
+			startErr:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fca := &fakeCgroupsAdapter{failures: tc.failures}
+			mutualCPUs := e2ecpuset.MustParse(sampleCPUs)
+			p := &Plugin{
+				MutualCPUs: &mutualCPUs,
+				Runtime:    cgroups.RuntimeCrio,
+				cgroups:    fca,
+			}
+			sb := makePodSandbox("test-sb")
+			ctr := makeContainer("test-ctr",
+				withLinuxResources("1,2", 20000),
+				withPeriod(100000),
+				withEnv(deviceplugin.EnvVarName+"="+sampleCPUs))
+
+			ca, _, err := p.CreateContainer(sb, ctr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ca.Hooks != nil {
+				t.Fatalf("expected no hooks to be injected, got: %+v", ca.Hooks)
+			}
+
+			err = p.PostCreateContainer(sb, ctr)
+			if tc.postCreateErr != (err != nil) {
+				t.Fatalf("unexpected PostCreateContainer result: %v", err)
+			}
+			err = p.StartContainer(sb, ctr)
+			if tc.startErr != (err != nil) {
+				t.Fatalf("unexpected StartContainer result: %v", err)
+			}
+			if p.getPending(ctr.GetId()) != nil {
+				t.Fatalf("expected no pending quota for container %q", ctr.GetId())
+			}
+			if got := fca.pod[sb.GetLinux().GetCgroupParent()]; got.Quota != tc.wantPodQuota || got.Period != tc.wantPeriod {
+				t.Errorf("unexpected pod quota; want: %d/%d, got: %d/%d", tc.wantPodQuota, tc.wantPeriod, got.Quota, got.Period)
+			}
+			if got := fca.ctr[ctr.GetId()]; got.Quota != tc.wantCtrQuota || got.Period != tc.wantPeriod {
+				t.Errorf("unexpected container quota; want: %d/%d, got: %d/%d", tc.wantCtrQuota, tc.wantPeriod, got.Quota, got.Period)
+			}
+		})
+	}
+}
+
+func TestUpdateMutualCPUs(t *testing.T) {
+	fca := &fakeCgroupsAdapter{}
+	fs := &fakeStub{}
+	mutualCPUs := e2ecpuset.MustParse("0")
+	p := &Plugin{
+		Stub:       fs,
+		MutualCPUs: &mutualCPUs,
+		Runtime:    cgroups.RuntimeCrio,
+		cgroups:    fca,
+	}
+	sb := makePodSandbox("test-sb")
+	requested := makeContainer("requested",
+		withLinuxResources("1,2", 30000),
+		withPeriod(100000),
+		withEnv(deviceplugin.EnvVarName+"=0"))
+	notRequested := makeContainer("not-requested",
+		withLinuxResources("3", 100000),
+		withPeriod(100000))
+
+	for _, ctr := range []*api.Container{requested, notRequested} {
+		if _, _, err := p.CreateContainer(sb, ctr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := p.UpdateMutualCPUs(e2ecpuset.MustParse("4-5")); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.getMutualCPUs().String(); got != "4-5" {
+		t.Fatalf("unexpected mutual cpus; want: %q, got: %q", "4-5", got)
+	}
+	if len(fs.updates) != 1 {
+		t.Fatalf("expected exactly one container update, got: %d", len(fs.updates))
+	}
+	u := fs.updates[0]
+	if u.ContainerId != requested.GetId() {
+		t.Fatalf("unexpected container updated; want: %q, got: %q", requested.GetId(), u.ContainerId)
+	}
+	lcpu := u.Linux.Resources.Cpu
+	if lcpu.Cpus != "1-2,4-5" {
+		t.Fatalf("unexpected cpuset; want: %q, got: %q", "1-2,4-5", lcpu.Cpus)
+	}
+	if lcpu.Quota.Value != 400000 {
+		t.Fatalf("unexpected quota; want: %d, got: %d", 400000, lcpu.Quota.Value)
+	}
+	// the pod's quota accounts for the container that did not request the mutual cpus as well
+	if got := fca.pod[sb.GetLinux().GetCgroupParent()]; got.Quota != 500000 {
+		t.Fatalf("unexpected pod quota; want: %d, got: %d", 500000, got.Quota)
+	}
+
+	if err := p.RemoveContainer(sb, requested); err != nil {
+		t.Fatal(err)
+	}
+	fs.updates = nil
+	if err := p.UpdateMutualCPUs(e2ecpuset.MustParse("6")); err != nil {
+		t.Fatal(err)
+	}
+	if len(fs.updates) != 0 {
+		t.Fatalf("expected no updates for removed containers, got: %+v", fs.updates)
+	}
+}
+
+func TestRemovePodSandbox(t *testing.T) {
+	fr := &fakeReleaser{}
+	mutualCPUs := e2ecpuset.MustParse("0")
+	p := &Plugin{
+		MutualCPUs: &mutualCPUs,
+		Pools:      []deviceplugin.Pool{{Name: "io", CPUs: e2ecpuset.MustParse("5")}},
+		Runtime:    cgroups.RuntimeCrio,
+		cgroups:    &fakeCgroupsAdapter{},
+		Devices:    fr,
+	}
+	sb := makePodSandbox("test-sb")
+	other := makePodSandbox("other-sb")
+	containers := map[*api.PodSandbox][]*api.Container{
+		sb: {
+			makeContainer("ctr1",
+				withLinuxResources("1", 100000),
+				withPeriod(100000),
+				withEnv(deviceplugin.EnvVarName+"=0", deviceplugin.DeviceIDsEnvVarName+"=3")),
+			makeContainer("ctr2",
+				withLinuxResources("2", 100000),
+				withPeriod(100000),
+				withEnv(deviceplugin.EnvVarName+"=0", deviceplugin.DeviceIDsEnvVarName+"=1",
+					deviceplugin.PoolEnvVarName("io")+"=5", deviceplugin.PoolDeviceIDsEnvVarName("io")+"=0")),
+		},
+		other: {
+			makeContainer("ctr1",
+				withLinuxResources("3", 100000),
+				withPeriod(100000),
+				withEnv(deviceplugin.EnvVarName+"=0", deviceplugin.DeviceIDsEnvVarName+"=7")),
+		},
+	}
+	for pod, ctrs := range containers {
+		for _, ctr := range ctrs {
+			if _, _, err := p.CreateContainer(pod, ctr); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// removing a container keeps the devices, since they belong to the pod
+	if err := p.RemoveContainer(sb, containers[sb][0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(fr.released) != 0 {
+		t.Fatalf("expected no released devices, got: %v", fr.released)
+	}
+
+	if err := p.RemovePodSandbox(sb); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		deviceplugin.MutualCPUResourceName:  {"1", "3"},
+		deviceplugin.PoolResourceName("io"): {"0"},
+	}
+	if !reflect.DeepEqual(fr.released, want) {
+		t.Fatalf("unexpected released devices; want: %v got: %v", want, fr.released)
+	}
+
+	// the pod's devices are released only once
+	fr.released = nil
+	if err := p.RemovePodSandbox(sb); err != nil {
+		t.Fatal(err)
+	}
+	if len(fr.released) != 0 {
+		t.Fatalf("expected no released devices, got: %v", fr.released)
+	}
+}
+
+func TestRequestedPoolsFromPodResources(t *testing.T) {
+	mutualCPUs := e2ecpuset.MustParse("0")
+	ioPool := deviceplugin.Pool{Name: "io", CPUs: e2ecpuset.MustParse("5")}
+	sb := makePodSandbox("test-sb")
+	testCases := []struct {
+		name      string
+		lister    *fakePodResources
+		env       []string
+		want      []string
+		devices   map[string][]string
+		wantCalls int
+	}{
+		{
+			name: "devices reported by kubelet",
+			lister: &fakePodResources{devices: map[string][]string{
+				deviceplugin.MutualCPUDeviceName: {"4"},
+				ioPool.DeviceName():              {"1"},
+			}},
+			env: []string{
+				deviceplugin.EnvVarName + "=0", deviceplugin.DeviceIDsEnvVarName + "=2",
+				ioPool.EnvVarName() + "=5", ioPool.DeviceIDsEnvVarName() + "=3",
+			},
+			want: []string{"", "io"},
+			devices: map[string][]string{
+				deviceplugin.MutualCPUResourceName: {"4"},
+				ioPool.ResourceName():              {"1"},
+			},
+			wantCalls: 1,
+		},
+		{
+			name: "no devices requested",
+			lister: &fakePodResources{devices: map[string][]string{
+				deviceplugin.MutualCPUDeviceName: {"4"},
+			}},
+			devices: map[string][]string{},
+		},
+		{
+			name:   "kubelet does not report the devices yet",
+			lister: &fakePodResources{devices: map[string][]string{}},
+			env:    []string{deviceplugin.EnvVarName + "=0", deviceplugin.DeviceIDsEnvVarName + "=2"},
+			want:   []string{""},
+			devices: map[string][]string{
+				deviceplugin.MutualCPUResourceName: {"2"},
+			},
+			wantCalls: 1,
+		},
+		{
+			name:   "fall back to environment variables",
+			lister: &fakePodResources{err: fmt.Errorf("fake failure")},
+			env:    []string{deviceplugin.EnvVarName + "=0", deviceplugin.DeviceIDsEnvVarName + "=2"},
+			want:   []string{""},
+			devices: map[string][]string{
+				deviceplugin.MutualCPUResourceName: {"2"},
+			},
+			wantCalls: 1,
+		},
+		{
+			name:   "container unknown to kubelet",
+			lister: &fakePodResources{},
+			env:    []string{ioPool.EnvVarName() + "=5"},
+			want:   []string{"io"},
+			devices: map[string][]string{
+				ioPool.ResourceName(): nil,
+			},
+			wantCalls: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &Plugin{
+				MutualCPUs:   &mutualCPUs,
+				Pools:        []deviceplugin.Pool{ioPool},
+				PodResources: tc.lister,
+			}
+			pools, devices := p.requestedPools(sb, makeContainer("test-ctr", withEnv(tc.env...)))
+			var names []string
+			for _, pool := range pools {
+				names = append(names, pool.Name)
+			}
+			if !reflect.DeepEqual(names, tc.want) {
+				t.Errorf("unexpected pools; want: %v got: %v", tc.want, names)
+			}
+			if !reflect.DeepEqual(devices, tc.devices) {
+				t.Errorf("unexpected devices; want: %v got: %v", tc.devices, devices)
+			}
+			if tc.lister.calls != tc.wantCalls {
+				t.Errorf("unexpected number of kubelet queries; want: %d got: %d", tc.wantCalls, tc.lister.calls)
+			}
+		})
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	testCases := []struct {
+		name        string
+		runtime     cgroups.Runtime
+		runtimeName string
+		want        cgroups.Runtime
+		isError     bool
+	}{
+		{
+			name:        "detect cri-o",
+			runtimeName: "cri-o",
+			want:        cgroups.RuntimeCrio,
+		},
+		{
+			name:        "detect containerd",
+			runtimeName: "containerd",
+			want:        cgroups.RuntimeContainerd,
+		},
+		{
+			name:        "explicit runtime takes precedence",
+			runtime:     cgroups.RuntimeContainerd,
+			runtimeName: "cri-o",
+			want:        cgroups.RuntimeContainerd,
+		},
+		{
+			name:        "unsupported runtime",
+			runtimeName: "foo",
+			isError:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &Plugin{Runtime: tc.runtime}
+			_, err := p.Configure("", tc.runtimeName, "v1")
+			if tc.isError {
+				if err == nil {
+					t.Fatalf("expected error for runtime %q", tc.runtimeName)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Runtime != tc.want {
+				t.Fatalf("unexpected runtime; want: %q, got: %q", tc.want, p.Runtime)
+			}
+		})
+	}
+}
+
 func makePodSandbox(name string, opts ...func(sb *api.PodSandbox)) *api.PodSandbox {
 	uid := string(uuid.NewUUID())
 	sb := &api.PodSandbox{
@@ -98,6 +570,7 @@ func makePodSandbox(name string, opts ...func(sb *api.PodSandbox)) *api.PodSandb
 
 func makeContainer(name string, opts ...func(ctr *api.Container)) *api.Container {
 	ctr := &api.Container{
+		Id:    string(uuid.NewUUID()),
 		Name:  name,
 		Linux: &api.LinuxContainer{},
 	}
@@ -107,6 +580,18 @@ func makeContainer(name string, opts ...func(ctr *api.Container)) *api.Container
 	return ctr
 }
 
+func withAnnotations(annotations map[string]string) func(sb *api.PodSandbox) {
+	return func(sb *api.PodSandbox) {
+		sb.Annotations = annotations
+	}
+}
+
+func withPodSandboxId(id string) func(ctr *api.Container) {
+	return func(ctr *api.Container) {
+		ctr.PodSandboxId = id
+	}
+}
+
 func withLinuxResources(cpus string, quota int64) func(ctr *api.Container) {
 	lres := &api.LinuxResources{
 		Cpu: &api.LinuxCPU{
@@ -119,6 +604,116 @@ func withLinuxResources(cpus string, quota int64) func(ctr *api.Container) {
 	}
 }
 
+func withPeriod(period uint64) func(ctr *api.Container) {
+	return func(ctr *api.Container) {
+		ctr.Linux.Resources.Cpu.Period = &api.OptionalUInt64{Value: period}
+	}
+}
+
+func withEnv(env ...string) func(ctr *api.Container) {
+	return func(ctr *api.Container) {
+		ctr.Env = append(ctr.Env, env...)
+	}
+}
+
+// fakeCgroupsAdapter records the quotas instead of writing them into cgroups
+type fakeCgroupsAdapter struct {
+	failures int
+	// noBurst fails the writes of a burst, like kernels without burst support
+	noBurst bool
+	pod     map[string]cgroups.CFSQuota
+	ctr     map[string]cgroups.CFSQuota
+}
+
+func (f *fakeCgroupsAdapter) SetCFSQuota(processCgroupPath string, quota cgroups.CFSQuota) error {
+	if f.failures > 0 {
+		f.failures--
+		return fmt.Errorf("fake failure")
+	}
+	if f.noBurst && quota.Burst != 0 {
+		return fmt.Errorf("fake: %w", cgroups.ErrBurstNotSupported)
+	}
+	if f.pod == nil {
+		f.pod = make(map[string]cgroups.CFSQuota)
+	}
+	f.pod[processCgroupPath] = quota
+	return nil
+}
+
+func (f *fakeCgroupsAdapter) SetContainerCFSQuota(parentPath, ctrId string, runtime cgroups.Runtime, quota cgroups.CFSQuota) error {
+	if f.failures > 0 {
+		f.failures--
+		return fmt.Errorf("fake failure")
+	}
+	if f.noBurst && quota.Burst != 0 {
+		return fmt.Errorf("fake: %w", cgroups.ErrBurstNotSupported)
+	}
+	if f.ctr == nil {
+		f.ctr = make(map[string]cgroups.CFSQuota)
+	}
+	f.ctr[ctrId] = quota
+	return nil
+}
+
+// fakeStub records the unsolicited container updates
+type fakeStub struct {
+	mu      sync.Mutex
+	updates []*api.ContainerUpdate
+	runErr  error
+	// onUpdate is called before the updates are recorded, when set
+	onUpdate func()
+	// updated gets the updates once they are recorded, when set
+	updated chan []*api.ContainerUpdate
+}
+
+func (f *fakeStub) Run(ctx context.Context) error   { return f.runErr }
+func (f *fakeStub) Start(ctx context.Context) error { return nil }
+func (f *fakeStub) Stop()                           {}
+func (f *fakeStub) Wait()                           {}
+
+func (f *fakeStub) UpdateContainers(updates []*api.ContainerUpdate) ([]*api.ContainerUpdate, error) {
+	if f.onUpdate != nil {
+		f.onUpdate()
+	}
+	f.mu.Lock()
+	f.updates = append(f.updates, updates...)
+	f.mu.Unlock()
+	if f.updated != nil {
+		f.updated <- updates
+	}
+	return nil, nil
+}
+
+func (f *fakeStub) getUpdates() []*api.ContainerUpdate {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*api.ContainerUpdate(nil), f.updates...)
+}
+
+// fakeReleaser records the released devices per resource
+type fakeReleaser struct {
+	released map[string][]string
+}
+
+func (f *fakeReleaser) Release(resourceName string, ids []string) {
+	if f.released == nil {
+		f.released = make(map[string][]string)
+	}
+	f.released[resourceName] = append(f.released[resourceName], ids...)
+}
+
+// fakePodResources reports the same devices for every container
+type fakePodResources struct {
+	devices map[string][]string
+	err     error
+	calls   int
+}
+
+func (f *fakePodResources) ContainerDevices(ctx context.Context, namespace, podName, containerName string) (map[string][]string, error) {
+	f.calls++
+	return f.devices, f.err
+}
+
 func generateCgroupParent(uid string) string {
 	return fmt.Sprintf("kubepods.slice/kubepods-pod%s.slice", strings.Replace(uid, "-", "_", -1))
 }
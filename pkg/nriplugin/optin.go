@@ -0,0 +1,80 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"strings"
+
+	"github.com/containerd/nri/pkg/api"
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/config"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/deviceplugin"
+)
+
+// SharedCPUsAnnotation is the pod annotation that lists the names of the containers,
+// comma separated, that should get the mutual cpus without requesting the device.
+// The containers of a named pool are listed in the annotation suffixed by "-<pool name>".
+const SharedCPUsAnnotation = "mixedcpus.openshift.io/shared-cpus"
+
+// PoolAnnotation returns the pod annotation that lists the containers requesting the pool
+func PoolAnnotation(pool deviceplugin.Pool) string {
+	if pool.Name == "" {
+		return SharedCPUsAnnotation
+	}
+	return SharedCPUsAnnotation + "-" + pool.Name
+}
+
+// annotatedPool checks whether the pod's annotations request the pool for the container
+func annotatedPool(pod *api.PodSandbox, ctr *api.Container, pool deviceplugin.Pool) bool {
+	value, ok := pod.GetAnnotations()[PoolAnnotation(pool)]
+	if !ok {
+		return false
+	}
+	for _, name := range strings.Split(value, ",") {
+		if strings.TrimSpace(name) == ctr.GetName() {
+			return true
+		}
+	}
+	return false
+}
+
+// exclusiveCPUs checks whether the container runs on exclusive cpus, which annotated containers need
+// along with the shared cpus, since the annotation alone does not go through kubelet's admission.
+// The CPU Manager gives exclusive cpus only to containers of Guaranteed pods, whose cgroup is not
+// under the burstable or besteffort ones, and the container must have cpus other than the shared cpus.
+func exclusiveCPUs(pod *api.PodSandbox, ctr *api.Container, sharedCPUs cpuset.CPUSet) bool {
+	parent := strings.ToLower(pod.GetLinux().GetCgroupParent())
+	if strings.Contains(parent, "burstable") || strings.Contains(parent, "besteffort") {
+		return false
+	}
+	cpus, err := getCtrCPUs(ctr)
+	if err != nil {
+		return false
+	}
+	return !cpus.Difference(sharedCPUs).IsEmpty()
+}
+
+// optInPolicy returns how containers request the shared cpus
+func (p *Plugin) optInPolicy() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Config == nil || p.Config.OptInPolicy == "" {
+		return config.OptInDevice
+	}
+	return p.Config.OptInPolicy
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/config"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/deviceplugin"
+	e2ecpuset "github.com/openshift-kni/mixed-cpu-node-plugin/test/e2e/cpuset"
+)
+
+func TestRequestedPoolsOptInPolicy(t *testing.T) {
+	mutualCPUs := e2ecpuset.MustParse("0")
+	ioPool := deviceplugin.Pool{Name: "io", CPUs: e2ecpuset.MustParse("5")}
+	annotations := map[string]string{
+		SharedCPUsAnnotation:      "test-ctr, other-ctr",
+		PoolAnnotation(ioPool):    "other-ctr",
+		"unrelated.io/annotation": "test-ctr",
+	}
+	testCases := []struct {
+		name        string
+		policy      string
+		env         []string
+		annotations map[string]string
+		want        []string
+	}{
+		{
+			name:        "device policy ignores the annotations",
+			policy:      config.OptInDevice,
+			annotations: annotations,
+		},
+		{
+			name:   "device policy honors the device",
+			policy: config.OptInDevice,
+			env:    []string{ioPool.EnvVarName() + "=5"},
+			want:   []string{"io"},
+		},
+		{
+			name:        "annotation policy ignores the device",
+			policy:      config.OptInAnnotation,
+			env:         []string{ioPool.EnvVarName() + "=5"},
+			annotations: annotations,
+			want:        []string{""},
+		},
+		{
+			name:   "annotation policy without annotations",
+			policy: config.OptInAnnotation,
+			env:    []string{deviceplugin.EnvVarName + "=0"},
+		},
+		{
+			name:        "both policies combined",
+			policy:      config.OptInBoth,
+			env:         []string{ioPool.EnvVarName() + "=5"},
+			annotations: annotations,
+			want:        []string{"", "io"},
+		},
+		{
+			name:        "no policy defaults to device",
+			annotations: annotations,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &Plugin{
+				MutualCPUs: &mutualCPUs,
+				Pools:      []deviceplugin.Pool{ioPool},
+			}
+			if tc.policy != "" {
+				p.Config = &config.Config{OptInPolicy: tc.policy}
+			}
+			sb := makePodSandbox("test-sb", withAnnotations(tc.annotations))
+			ctr := makeContainer("test-ctr", withLinuxResources("2-3", 20000), withEnv(tc.env...))
+			pools, _ := p.requestedPools(sb, ctr)
+			var names []string
+			for _, pool := range pools {
+				names = append(names, pool.Name)
+			}
+			if !reflect.DeepEqual(names, tc.want) {
+				t.Errorf("unexpected pools; want: %v got: %v", tc.want, names)
+			}
+		})
+	}
+}
+
+func TestRequestedPoolsExclusiveCPUs(t *testing.T) {
+	mutualCPUs := e2ecpuset.MustParse("0")
+	ioPool := deviceplugin.Pool{Name: "io", CPUs: e2ecpuset.MustParse("5")}
+	annotations := map[string]string{SharedCPUsAnnotation: "test-ctr"}
+	testCases := []struct {
+		name         string
+		cgroupParent string
+		cpus         string
+		env          []string
+		want         []string
+	}{
+		{
+			name: "exclusive cpus",
+			cpus: "2-3",
+			want: []string{""},
+		},
+		{
+			name: "exclusive cpus along with the shared cpus",
+			cpus: "0,2-3",
+			want: []string{""},
+		},
+		{
+			name: "shared cpus only",
+			cpus: "0,5",
+		},
+		{
+			name: "no cpus",
+		},
+		{
+			name:         "burstable pod",
+			cgroupParent: "kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1234.slice",
+			cpus:         "2-3",
+		},
+		{
+			name:         "besteffort pod",
+			cgroupParent: "/kubepods/besteffort/pod1234",
+			cpus:         "2-3",
+		},
+		{
+			name:         "burstable pod keeps the requested device",
+			cgroupParent: "/kubepods/burstable/pod1234",
+			cpus:         "2-3",
+			env:          []string{ioPool.EnvVarName() + "=5"},
+			want:         []string{"io"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &Plugin{
+				MutualCPUs: &mutualCPUs,
+				Pools:      []deviceplugin.Pool{ioPool},
+				Config:     &config.Config{OptInPolicy: config.OptInBoth},
+			}
+			sb := makePodSandbox("test-sb", withAnnotations(annotations))
+			if tc.cgroupParent != "" {
+				sb.Linux.CgroupParent = tc.cgroupParent
+			}
+			ctr := makeContainer("test-ctr", withEnv(tc.env...))
+			if tc.cpus != "" {
+				ctr = makeContainer("test-ctr", withLinuxResources(tc.cpus, 20000), withEnv(tc.env...))
+			}
+			pools, _ := p.requestedPools(sb, ctr)
+			var names []string
+			for _, pool := range pools {
+				names = append(names, pool.Name)
+			}
+			if !reflect.DeepEqual(names, tc.want) {
+				t.Errorf("unexpected pools; want: %v got: %v", tc.want, names)
+			}
+		})
+	}
+}
+
+func TestCreateContainerAnnotated(t *testing.T) {
+	mutualCPUs := e2ecpuset.MustParse("0")
+	ioPool := deviceplugin.Pool{Name: "io", CPUs: e2ecpuset.MustParse("5")}
+	p := &Plugin{
+		MutualCPUs: &mutualCPUs,
+		Pools:      []deviceplugin.Pool{ioPool},
+		Config:     &config.Config{OptInPolicy: config.OptInBoth},
+	}
+	sb := makePodSandbox("test-sb", withAnnotations(map[string]string{SharedCPUsAnnotation: "test-ctr"}))
+	ctr := makeContainer("test-ctr",
+		withLinuxResources("2-3", 20000),
+		withPeriod(100000),
+		withEnv(ioPool.EnvVarName()+"=5"))
+
+	ca, _, err := p.CreateContainer(sb, ctr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ca.Linux.Resources.Cpu.Cpus, "0,2-3,5"; got != want {
+		t.Fatalf("unexpected cpuset; want: %q, got: %q", want, got)
+	}
+	gotEnv := make(map[string]string)
+	for _, kv := range ca.Env {
+		gotEnv[kv.Key] = kv.Value
+	}
+	// the device plugin already set the env var of the requested device
+	wantEnv := map[string]string{deviceplugin.EnvVarName: "0"}
+	if !reflect.DeepEqual(gotEnv, wantEnv) {
+		t.Fatalf("unexpected env; want: %v, got: %v", wantEnv, gotEnv)
+	}
+}
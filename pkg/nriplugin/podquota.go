@@ -0,0 +1,236 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"github.com/containerd/nri/pkg/api"
+	"github.com/golang/glog"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/cgroups"
+)
+
+// podQuota keeps the cfs quota of every container of a pod, so the quota
+// of the pod's cgroup accounts for all of them and not only for the last one updated.
+type podQuota struct {
+	cgroupParent string
+	// period is the cfs period the pod's quota is calculated in
+	period uint64
+	// members maps the names of the pod's running containers to their cfs quota.
+	// Containers that ran with shared cpus keep their original share once stopped, so it is
+	// there when they restart, and the other containers are dropped once stopped, e.g. init and
+	// ephemeral containers, which do not run along with the rest for good.
+	// Removed containers are gone for good, so they are dropped as well.
+	members map[string]*podMember
+	// shared is true once any of the pod's containers ran with shared cpus
+	shared bool
+}
+
+// podMember is the cfs quota of a container of the pod
+type podMember struct {
+	// id of the container's latest instance
+	id    string
+	quota cgroups.CFSQuota
+	// original is the quota the container was given by kubelet,
+	// before the shared cpus were added to it
+	original cgroups.CFSQuota
+	// shared is true while the container is running with shared cpus
+	shared bool
+}
+
+// total returns the quota of the pod, which is the sum of its containers' quotas,
+// along with the sum of their bursts. The pod is unlimited when any of its containers is.
+func (pq *podQuota) total() cgroups.CFSQuota {
+	q := cgroups.CFSQuota{Period: pq.period}
+	for _, m := range pq.members {
+		if m.quota.Quota <= 0 {
+			return cgroups.CFSQuota{Quota: -1, Period: pq.period}
+		}
+		// the runtime uses the same period for all the containers,
+		// but make sure the quotas add up in the pod's period anyway
+		if m.quota.Period != 0 && m.quota.Period != pq.period {
+			q.Quota += m.quota.Quota * int64(pq.period) / int64(m.quota.Period)
+			q.Burst += m.quota.Burst * pq.period / m.quota.Period
+			continue
+		}
+		q.Quota += m.quota.Quota
+		q.Burst += m.quota.Burst
+	}
+	return q
+}
+
+// managed checks whether the plugin manages the quota of the pod's cgroup,
+// which it does from the time any of its containers ran with shared cpus,
+// so the pod's quota keeps following its containers after they stopped.
+func (pq *podQuota) managed() bool {
+	return pq.shared
+}
+
+// RunPodSandbox starts the quota bookkeeping of the pod.
+func (p *Plugin) RunPodSandbox(pod *api.PodSandbox) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.getPodQuotaLocked(pod)
+	return nil
+}
+
+// getPodQuotaLocked returns the quota bookkeeping of the pod, and starts it if needed,
+// e.g. for pods that were running before the plugin started.
+// Must be called with p.mu held.
+func (p *Plugin) getPodQuotaLocked(pod *api.PodSandbox) *podQuota {
+	if p.podQuotas == nil {
+		p.podQuotas = make(map[string]*podQuota)
+	}
+	pq, ok := p.podQuotas[pod.GetId()]
+	if !ok {
+		pq = &podQuota{
+			cgroupParent: pod.GetLinux().GetCgroupParent(),
+			members:      make(map[string]*podMember),
+		}
+		p.podQuotas[pod.GetId()] = pq
+	}
+	return pq
+}
+
+// setMemberQuota records the quota of the pod's container and returns
+// the pod's quota before and after the change, and whether the plugin manages it.
+// A nil original quota keeps the one that was recorded for the container before, if any.
+func (p *Plugin) setMemberQuota(pod *api.PodSandbox, ctr *api.Container, quota cgroups.CFSQuota, original *cgroups.CFSQuota, shared bool) (before, after cgroups.CFSQuota, managed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pq := p.getPodQuotaLocked(pod)
+	before = pq.total()
+	m, ok := pq.members[ctr.GetName()]
+	if !ok || m.id != ctr.GetId() {
+		m = &podMember{id: ctr.GetId(), original: quota}
+		pq.members[ctr.GetName()] = m
+	}
+	if original != nil {
+		m.original = *original
+	}
+	m.quota = quota
+	m.shared = shared
+	pq.shared = pq.shared || shared
+	if quota.Period != 0 {
+		pq.period = quota.Period
+	}
+	return before, pq.total(), pq.managed()
+}
+
+// syncMembers drops the pods and the containers that are gone from the quota bookkeeping,
+// e.g. the ones removed while the plugin was disconnected from the runtime,
+// and returns the ids of the containers whose quota was recorded before.
+func (p *Plugin) syncMembers(pods []*api.PodSandbox, containers []*api.Container) map[string]bool {
+	podIDs := make(map[string]bool, len(pods))
+	for _, pod := range pods {
+		podIDs[pod.GetId()] = true
+	}
+	ctrIDs := make(map[string]bool, len(containers))
+	for _, ctr := range containers {
+		ctrIDs[ctr.GetId()] = true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	known := make(map[string]bool)
+	for podId, pq := range p.podQuotas {
+		if !podIDs[podId] {
+			delete(p.podQuotas, podId)
+			continue
+		}
+		for name, m := range pq.members {
+			if !ctrIDs[m.id] {
+				delete(pq.members, name)
+				continue
+			}
+			known[m.id] = true
+		}
+	}
+	return known
+}
+
+// releaseMember drops the container from the pod's quota once it stopped or was removed.
+// A stopped container that was running with shared cpus is rolled back to its original quota instead,
+// which it keeps until it is removed. It returns the pod's quota, whether the plugin manages it,
+// and the container's original quota when it was running with shared cpus.
+func (p *Plugin) releaseMember(pod *api.PodSandbox, ctr *api.Container, removed bool) (quota cgroups.CFSQuota, managed bool, original *cgroups.CFSQuota) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pq, ok := p.podQuotas[pod.GetId()]
+	if !ok {
+		return cgroups.CFSQuota{}, false, nil
+	}
+	managed = pq.managed()
+	m, ok := pq.members[ctr.GetName()]
+	if !ok || m.id != ctr.GetId() {
+		return pq.total(), managed, nil
+	}
+	if m.shared {
+		q := m.original
+		original = &q
+	}
+	if m.shared && !removed {
+		m.quota = m.original
+		m.shared = false
+	} else {
+		delete(pq.members, ctr.GetName())
+	}
+	return pq.total(), managed, original
+}
+
+// podCFSQuota returns the quota of the pod's cgroup, and whether the plugin manages it
+func (p *Plugin) podCFSQuota(podId string) (cgroups.CFSQuota, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pq, ok := p.podQuotas[podId]
+	if !ok {
+		return cgroups.CFSQuota{}, false
+	}
+	return pq.total(), pq.managed()
+}
+
+// setPodCFSQuota writes the quota into the pod's cgroup.
+// Failures are only logged, the pod's quota is written again on the next change of its containers.
+func (p *Plugin) setPodCFSQuota(cgroupParent string, quota cgroups.CFSQuota) {
+	if err := p.getCgroupsAdapter().SetCFSQuota(cgroupParent, quota); err != nil {
+		glog.Errorf("failed to set pod %q cfs quota: %v", cgroupParent, err)
+		return
+	}
+	glog.V(4).Infof("pod %q cgroups quota set to: %d", cgroupParent, quota.Quota)
+}
+
+// ctrCFSQuota returns the cfs quota in the container's resources.
+// A container without a quota is unlimited.
+func ctrCFSQuota(resources *api.LinuxResources) cgroups.CFSQuota {
+	cpu := resources.GetCpu()
+	q := cgroups.CFSQuota{
+		Quota:  cpu.GetQuota().GetValue(),
+		Period: cpu.GetPeriod().GetValue(),
+	}
+	if cpu.GetQuota() == nil || q.Quota <= 0 {
+		q.Quota = -1
+	}
+	return q
+}
+
+// quotaGrows checks whether the quota is raised, in which case it has to be
+// written into the pod's cgroup before the container's cgroup is updated.
+func quotaGrows(before, after cgroups.CFSQuota) bool {
+	if after.Quota < 0 {
+		return before.Quota >= 0
+	}
+	return before.Quota >= 0 && after.Quota > before.Quota
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"testing"
+
+	"github.com/containerd/nri/pkg/api"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/cgroups"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/deviceplugin"
+	e2ecpuset "github.com/openshift-kni/mixed-cpu-node-plugin/test/e2e/cpuset"
+)
+
+func TestPodQuotaTotal(t *testing.T) {
+	testCases := []struct {
+		name      string
+		period    uint64
+		members   map[string]cgroups.CFSQuota
+		want      int64
+		wantBurst uint64
+	}{
+		{
+			name:   "sum of the containers",
+			period: 100000,
+			members: map[string]cgroups.CFSQuota{
+				"a": {Quota: 300000, Period: 100000},
+				"b": {Quota: 50000, Period: 100000},
+			},
+			want: 350000,
+		},
+		{
+			name:   "unlimited container",
+			period: 100000,
+			members: map[string]cgroups.CFSQuota{
+				"a": {Quota: 300000, Period: 100000},
+				"b": {Quota: -1, Period: 100000},
+			},
+			want: -1,
+		},
+		{
+			name:   "containers with different periods",
+			period: 100000,
+			members: map[string]cgroups.CFSQuota{
+				"a": {Quota: 300000, Period: 100000},
+				"b": {Quota: 25000, Period: 50000},
+			},
+			want: 350000,
+		},
+		{
+			name:   "containers with bursts",
+			period: 100000,
+			members: map[string]cgroups.CFSQuota{
+				"a": {Quota: 200000, Period: 100000, Burst: 100000},
+				"b": {Quota: 100000, Period: 100000, Burst: 50000},
+			},
+			want:      300000,
+			wantBurst: 150000,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pq := &podQuota{period: tc.period, members: make(map[string]*podMember)}
+			for name, quota := range tc.members {
+				pq.members[name] = &podMember{id: name, quota: quota}
+			}
+			if got := pq.total(); got.Quota != tc.want || got.Period != tc.period || got.Burst != tc.wantBurst {
+				t.Errorf("unexpected pod quota; want: %d/%d burst %d got: %d/%d burst %d",
+					tc.want, tc.period, tc.wantBurst, got.Quota, got.Period, got.Burst)
+			}
+		})
+	}
+}
+
+func TestPodQuotaMultipleContainers(t *testing.T) {
+	fca := &fakeCgroupsAdapter{}
+	mutualCPUs := e2ecpuset.MustParse("0")
+	p := &Plugin{
+		MutualCPUs: &mutualCPUs,
+		Runtime:    cgroups.RuntimeCrio,
+		cgroups:    fca,
+	}
+	sb := makePodSandbox("test-sb")
+	podQuota := func() int64 {
+		return fca.pod[sb.GetLinux().GetCgroupParent()].Quota
+	}
+	if err := p.RunPodSandbox(sb); err != nil {
+		t.Fatal(err)
+	}
+
+	first := makeContainer("first",
+		withLinuxResources("1,2", 200000),
+		withPeriod(100000),
+		withEnv(deviceplugin.EnvVarName+"=0"))
+	second := makeContainer("second",
+		withLinuxResources("3", 100000),
+		withPeriod(100000),
+		withEnv(deviceplugin.EnvVarName+"=0"))
+	for _, ctr := range []*api.Container{first, second} {
+		if _, _, err := p.CreateContainer(sb, ctr); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.PostCreateContainer(sb, ctr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the second container does not override the quota of the first one
+	if got := podQuota(); got != 500000 {
+		t.Fatalf("unexpected pod quota; want: %d got: %d", 500000, got)
+	}
+
+	sidecar := makeContainer("sidecar",
+		withLinuxResources("4", 100000),
+		withPeriod(100000))
+	if _, _, err := p.CreateContainer(sb, sidecar); err != nil {
+		t.Fatal(err)
+	}
+	if got := podQuota(); got != 600000 {
+		t.Fatalf("unexpected pod quota after creating a container without shared cpus; want: %d got: %d", 600000, got)
+	}
+
+	// in-place resize up is written before the runtime updates the container
+	resized := makeContainer("sidecar", withLinuxResources("4-5", 200000), withPeriod(100000))
+	resized.Id = sidecar.GetId()
+	if _, err := p.UpdateContainer(sb, resized); err != nil {
+		t.Fatal(err)
+	}
+	if got := podQuota(); got != 700000 {
+		t.Fatalf("unexpected pod quota after resizing up; want: %d got: %d", 700000, got)
+	}
+
+	// in-place resize down is written once the runtime updated the container
+	resized = makeContainer("sidecar", withLinuxResources("4", 50000), withPeriod(100000))
+	resized.Id = sidecar.GetId()
+	if _, err := p.UpdateContainer(sb, resized); err != nil {
+		t.Fatal(err)
+	}
+	if got := podQuota(); got != 700000 {
+		t.Fatalf("expected the pod quota to be kept until the container is updated; want: %d got: %d", 700000, got)
+	}
+	if err := p.PostUpdateContainer(sb, resized); err != nil {
+		t.Fatal(err)
+	}
+	if got := podQuota(); got != 550000 {
+		t.Fatalf("unexpected pod quota after resizing down; want: %d got: %d", 550000, got)
+	}
+
+	// a CPU Manager update of a container with shared cpus keeps them in the pod's quota
+	updated := makeContainer("second",
+		withLinuxResources("3,6", 200000),
+		withPeriod(100000),
+		withEnv(deviceplugin.EnvVarName+"=0"))
+	updated.Id = second.GetId()
+	if _, err := p.UpdateContainer(sb, updated); err != nil {
+		t.Fatal(err)
+	}
+	if got := podQuota(); got != 650000 {
+		t.Fatalf("unexpected pod quota after a CPU Manager update; want: %d got: %d", 650000, got)
+	}
+
+	if err := p.RemoveContainer(sb, second); err != nil {
+		t.Fatal(err)
+	}
+	// the removed container is gone for good, so it no longer counts in the pod's quota
+	if got := podQuota(); got != 350000 {
+		t.Fatalf("unexpected pod quota after removing a container; want: %d got: %d", 350000, got)
+	}
+
+	if err := p.RemovePodSandbox(sb); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.podQuotas[sb.GetId()]; ok {
+		t.Fatalf("expected the pod's quota bookkeeping to be dropped")
+	}
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"errors"
+
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+
+	"github.com/containerd/nri/pkg/api"
+	"github.com/golang/glog"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/cgroups"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/config"
+)
+
+const (
+	// CFSQuotaPolicyAnnotation is the pod annotation that overrides
+	// the node's cfs quota policy for the pod's containers
+	CFSQuotaPolicyAnnotation = "mixedcpus.openshift.io/cfs-quota-policy"
+
+	// crioCPUQuotaAnnotation is the CRI-O pod annotation that disables the cfs quota of the pod's containers
+	crioCPUQuotaAnnotation = "cpu-quota.crio.io"
+	crioDisable            = "disable"
+)
+
+// quotaPolicy returns the cfs quota policy of the pod's containers.
+// Invalid policies given by the pod's annotation are ignored.
+func (p *Plugin) quotaPolicy(pod *api.PodSandbox) string {
+	p.mu.Lock()
+	policy := config.QuotaPolicyProportional
+	var sharedMilliCPUs int64
+	if p.Config != nil && p.Config.CFSQuotaPolicy != "" {
+		policy = p.Config.CFSQuotaPolicy
+		sharedMilliCPUs = p.Config.SharedMilliCPUs
+	}
+	p.mu.Unlock()
+
+	value, ok := pod.GetAnnotations()[CFSQuotaPolicyAnnotation]
+	if !ok {
+		return policy
+	}
+	if err := config.ValidateQuotaPolicy(value); err != nil {
+		glog.Warningf("pod %s/%s: ignoring annotation %q: %v", pod.GetNamespace(), pod.GetName(), CFSQuotaPolicyAnnotation, err)
+		return policy
+	}
+	if value == config.QuotaPolicyFixed && sharedMilliCPUs == 0 {
+		glog.Warningf("pod %s/%s: ignoring annotation %q: no sharedMilliCPUs configured for the node", pod.GetNamespace(), pod.GetName(), CFSQuotaPolicyAnnotation)
+		return policy
+	}
+	return value
+}
+
+// cfsQuotaEnabled checks whether the plugin manages the cfs quota of the container.
+// It does not when CRI-O disables the pod's cfs quota, or when the container has
+// no quota or period, e.g. when kubelet runs with cpuCFSQuota disabled.
+func cfsQuotaEnabled(pod *api.PodSandbox, cpu *api.LinuxCPU) bool {
+	if crioCFSQuotaDisabled(pod) {
+		return false
+	}
+	return cpu.GetQuota().GetValue() > 0 && cpu.GetPeriod().GetValue() > 0
+}
+
+// crioCFSQuotaDisabled checks whether the pod asks CRI-O to disable its cfs quota
+func crioCFSQuotaDisabled(pod *api.PodSandbox) bool {
+	return pod.GetAnnotations()[crioCPUQuotaAnnotation] == crioDisable
+}
+
+// getSharedMilliCPUsLocked returns the budget of the shared cpus under the fixed policy.
+// Must be called with p.mu held.
+func (p *Plugin) getSharedMilliCPUsLocked() int64 {
+	if p.Config == nil {
+		return 0
+	}
+	return p.Config.SharedMilliCPUs
+}
+
+func (p *Plugin) getSharedMilliCPUs() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.getSharedMilliCPUsLocked()
+}
+
+// cfsQuotaFor returns the cfs quota of a container that runs on the exclusive and shared cpus:
+//   - proportional: the quota covers all of the cpus
+//   - unlimited: the container has no quota
+//   - fixed: the quota covers the exclusive cpus and the shared cpus budget
+//   - burst: the quota covers the exclusive cpus, and the container can burst
+//     with unused quota up to the size of the shared cpus
+func cfsQuotaFor(policy string, sharedMilliCPUs int64, exclusive, shared cpuset.CPUSet, period uint64) cgroups.CFSQuota {
+	q := cgroups.CFSQuota{Period: period}
+	switch policy {
+	case config.QuotaPolicyUnlimited:
+		q.Quota = -1
+	case config.QuotaPolicyFixed:
+		q.Quota = cfsQuotaForMilliCPUs(int64(exclusive.Size())*milliCPUToCPU+sharedMilliCPUs, period)
+	case config.QuotaPolicyBurst:
+		q.Quota = cfsQuotaForMilliCPUs(int64(exclusive.Size())*milliCPUToCPU, period)
+		// the burst is capped at the quota once it is written, but it is kept whole here,
+		// so the quota along with the burst is the proportional quota
+		q.Burst = uint64(cfsQuotaForMilliCPUs(int64(shared.Difference(exclusive).Size())*milliCPUToCPU, period))
+	default:
+		q.Quota = cfsQuotaForMilliCPUs(int64(exclusive.Union(shared).Size())*milliCPUToCPU, period)
+	}
+	return q
+}
+
+func cfsQuotaForMilliCPUs(milliCPUs int64, period uint64) int64 {
+	return (milliCPUs * int64(period)) / milliCPUToCPU
+}
+
+// burstFallbackAdapter writes the proportional quota instead of the quota and the burst
+// on kernels without burst support, so the containers can still use their shared cpus.
+type burstFallbackAdapter struct {
+	cgroupsAdapter
+}
+
+func (a burstFallbackAdapter) SetCFSQuota(processCgroupPath string, quota cgroups.CFSQuota) error {
+	err := a.cgroupsAdapter.SetCFSQuota(processCgroupPath, quota)
+	if !errors.Is(err, cgroups.ErrBurstNotSupported) {
+		return err
+	}
+	glog.Warningf("cgroup %q: %v, setting the proportional quota instead", processCgroupPath, err)
+	return a.cgroupsAdapter.SetCFSQuota(processCgroupPath, withoutBurst(quota))
+}
+
+func (a burstFallbackAdapter) SetContainerCFSQuota(parentPath, ctrId string, runtime cgroups.Runtime, quota cgroups.CFSQuota) error {
+	err := a.cgroupsAdapter.SetContainerCFSQuota(parentPath, ctrId, runtime, quota)
+	if !errors.Is(err, cgroups.ErrBurstNotSupported) {
+		return err
+	}
+	glog.Warningf("container %q: %v, setting the proportional quota instead", ctrId, err)
+	return a.cgroupsAdapter.SetContainerCFSQuota(parentPath, ctrId, runtime, withoutBurst(quota))
+}
+
+// withoutBurst returns the quota that covers the burst as well, which under the burst policy
+// is the proportional quota, i.e. of both the exclusive and the shared cpus
+func withoutBurst(quota cgroups.CFSQuota) cgroups.CFSQuota {
+	q := cgroups.CFSQuota{Quota: quota.Quota, Period: quota.Period}
+	if q.Quota >= 0 {
+		q.Quota += int64(quota.Burst)
+	}
+	return q
+}
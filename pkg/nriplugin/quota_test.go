@@ -0,0 +1,403 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"testing"
+
+	"github.com/containerd/nri/pkg/api"
+	"k8s.io/apimachinery/pkg/util/uuid"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/cgroups"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/config"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/deviceplugin"
+	e2ecpuset "github.com/openshift-kni/mixed-cpu-node-plugin/test/e2e/cpuset"
+)
+
+func TestCFSQuotaFor(t *testing.T) {
+	exclusive := e2ecpuset.MustParse("2-3")
+	testCases := []struct {
+		name   string
+		policy string
+		shared string
+		want   cgroups.CFSQuota
+	}{
+		{
+			name:   "proportional",
+			policy: config.QuotaPolicyProportional,
+			shared: "0-1",
+			want:   cgroups.CFSQuota{Quota: 400000, Period: 100000},
+		},
+		{
+			name:   "no policy is proportional",
+			shared: "0-1",
+			want:   cgroups.CFSQuota{Quota: 400000, Period: 100000},
+		},
+		{
+			name:   "unlimited",
+			policy: config.QuotaPolicyUnlimited,
+			shared: "0-1",
+			want:   cgroups.CFSQuota{Quota: -1, Period: 100000},
+		},
+		{
+			name:   "fixed",
+			policy: config.QuotaPolicyFixed,
+			shared: "0-1",
+			want:   cgroups.CFSQuota{Quota: 250000, Period: 100000},
+		},
+		{
+			name:   "burst",
+			policy: config.QuotaPolicyBurst,
+			shared: "0",
+			want:   cgroups.CFSQuota{Quota: 200000, Period: 100000, Burst: 100000},
+		},
+		{
+			name:   "burst beyond the quota",
+			policy: config.QuotaPolicyBurst,
+			shared: "0-1,4-5",
+			want:   cgroups.CFSQuota{Quota: 200000, Period: 100000, Burst: 400000},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := cfsQuotaFor(tc.policy, 500, exclusive, e2ecpuset.MustParse(tc.shared), 100000)
+			if got != tc.want {
+				t.Errorf("unexpected quota; want: %+v got: %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestQuotaPolicy(t *testing.T) {
+	testCases := []struct {
+		name            string
+		nodePolicy      string
+		sharedMilliCPUs int64
+		annotations     map[string]string
+		want            string
+	}{
+		{
+			name: "no configuration",
+			want: config.QuotaPolicyProportional,
+		},
+		{
+			name:       "node policy",
+			nodePolicy: config.QuotaPolicyBurst,
+			want:       config.QuotaPolicyBurst,
+		},
+		{
+			name:        "pod annotation overrides the node policy",
+			nodePolicy:  config.QuotaPolicyBurst,
+			annotations: map[string]string{CFSQuotaPolicyAnnotation: config.QuotaPolicyUnlimited},
+			want:        config.QuotaPolicyUnlimited,
+		},
+		{
+			name:        "invalid pod annotation",
+			nodePolicy:  config.QuotaPolicyBurst,
+			annotations: map[string]string{CFSQuotaPolicyAnnotation: "none"},
+			want:        config.QuotaPolicyBurst,
+		},
+		{
+			name:        "fixed pod annotation without a budget",
+			nodePolicy:  config.QuotaPolicyProportional,
+			annotations: map[string]string{CFSQuotaPolicyAnnotation: config.QuotaPolicyFixed},
+			want:        config.QuotaPolicyProportional,
+		},
+		{
+			name:            "fixed pod annotation",
+			nodePolicy:      config.QuotaPolicyProportional,
+			sharedMilliCPUs: 500,
+			annotations:     map[string]string{CFSQuotaPolicyAnnotation: config.QuotaPolicyFixed},
+			want:            config.QuotaPolicyFixed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &Plugin{}
+			if tc.nodePolicy != "" {
+				p.Config = &config.Config{CFSQuotaPolicy: tc.nodePolicy, SharedMilliCPUs: tc.sharedMilliCPUs}
+			}
+			if got := p.quotaPolicy(makePodSandbox("test-sb", withAnnotations(tc.annotations))); got != tc.want {
+				t.Errorf("unexpected policy; want: %q got: %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCreateContainerBurst(t *testing.T) {
+	fca := &fakeCgroupsAdapter{}
+	mutualCPUs := e2ecpuset.MustParse("0")
+	p := &Plugin{
+		MutualCPUs: &mutualCPUs,
+		Runtime:    cgroups.RuntimeCrio,
+		Config:     &config.Config{CFSQuotaPolicy: config.QuotaPolicyBurst},
+		cgroups:    fca,
+	}
+	sb := makePodSandbox("test-sb")
+	ctr := makeContainer("test-ctr",
+		withLinuxResources("1,2", 200000),
+		withPeriod(100000),
+		withEnv(deviceplugin.EnvVarName+"=0"))
+	if _, _, err := p.CreateContainer(sb, ctr); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PostCreateContainer(sb, ctr); err != nil {
+		t.Fatal(err)
+	}
+
+	want := cgroups.CFSQuota{Quota: 200000, Period: 100000, Burst: 100000}
+	if got := fca.ctr[ctr.GetId()]; got != want {
+		t.Errorf("unexpected container quota; want: %+v got: %+v", want, got)
+	}
+	if got := fca.pod[sb.GetLinux().GetCgroupParent()]; got != want {
+		t.Errorf("unexpected pod quota; want: %+v got: %+v", want, got)
+	}
+
+	// the burst follows the mutual cpus
+	p.Stub = &fakeStub{}
+	if err := p.UpdateMutualCPUs(e2ecpuset.MustParse("0,3")); err != nil {
+		t.Fatal(err)
+	}
+	want.Burst = 200000
+	if got := fca.ctr[ctr.GetId()]; got != want {
+		t.Errorf("unexpected container quota after updating the mutual cpus; want: %+v got: %+v", want, got)
+	}
+}
+
+func TestCreateContainerBurstNotSupported(t *testing.T) {
+	fca := &fakeCgroupsAdapter{noBurst: true}
+	mutualCPUs := e2ecpuset.MustParse("0,3-4")
+	p := &Plugin{
+		MutualCPUs: &mutualCPUs,
+		Runtime:    cgroups.RuntimeCrio,
+		Config:     &config.Config{CFSQuotaPolicy: config.QuotaPolicyBurst},
+		cgroups:    fca,
+	}
+	sb := makePodSandbox("test-sb")
+	ctr := makeContainer("test-ctr",
+		withLinuxResources("1,2", 200000),
+		withPeriod(100000),
+		withEnv(deviceplugin.EnvVarName+"=0,3-4"))
+	if _, _, err := p.CreateContainer(sb, ctr); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PostCreateContainer(sb, ctr); err != nil {
+		t.Fatal(err)
+	}
+
+	// the kernel does not support bursting, so the quota covers all of the cpus,
+	// even though the burst would have been capped at the quota
+	want := cgroups.CFSQuota{Quota: 500000, Period: 100000}
+	if got := fca.ctr[ctr.GetId()]; got != want {
+		t.Errorf("unexpected container quota; want: %+v got: %+v", want, got)
+	}
+	if got := fca.pod[sb.GetLinux().GetCgroupParent()]; got != want {
+		t.Errorf("unexpected pod quota; want: %+v got: %+v", want, got)
+	}
+}
+
+func TestUnmanagedCFSQuota(t *testing.T) {
+	withQuota := func(quota *api.OptionalInt64, period *api.OptionalUInt64) func(ctr *api.Container) {
+		return func(ctr *api.Container) {
+			ctr.Linux.Resources = &api.LinuxResources{
+				Cpu: &api.LinuxCPU{Cpus: "1,2", Quota: quota, Period: period},
+			}
+		}
+	}
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		quota       *api.OptionalInt64
+		period      *api.OptionalUInt64
+	}{
+		{
+			name:        "crio cpu quota disabled",
+			annotations: map[string]string{crioCPUQuotaAnnotation: crioDisable},
+			quota:       &api.OptionalInt64{Value: 200000},
+			period:      &api.OptionalUInt64{Value: 100000},
+		},
+		{
+			name:        "crio cpu quota disabled without a quota",
+			annotations: map[string]string{crioCPUQuotaAnnotation: crioDisable},
+			period:      &api.OptionalUInt64{Value: 100000},
+		},
+		{
+			name:   "no quota",
+			period: &api.OptionalUInt64{Value: 100000},
+		},
+		{
+			name:  "no period",
+			quota: &api.OptionalInt64{Value: 200000},
+		},
+		{
+			name: "no quota and no period",
+		},
+		{
+			name:   "unlimited quota",
+			quota:  &api.OptionalInt64{Value: -1},
+			period: &api.OptionalUInt64{Value: 100000},
+		},
+		{
+			name:   "zero quota",
+			quota:  &api.OptionalInt64{Value: 0},
+			period: &api.OptionalUInt64{Value: 100000},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fca := &fakeCgroupsAdapter{}
+			fs := &fakeStub{}
+			mutualCPUs := e2ecpuset.MustParse("0")
+			p := &Plugin{
+				MutualCPUs: &mutualCPUs,
+				Runtime:    cgroups.RuntimeCrio,
+				Stub:       fs,
+				cgroups:    fca,
+			}
+			sb := makePodSandbox("test-sb", withAnnotations(tc.annotations))
+			id := string(uuid.NewUUID())
+			// every hook gets its own copy of the container, like from the runtime
+			newCtr := func() *api.Container {
+				ctr := makeContainer("test-ctr",
+					withQuota(tc.quota, tc.period),
+					withEnv(deviceplugin.EnvVarName+"=0"),
+					withPodSandboxId(sb.GetId()))
+				ctr.Id = id
+				return ctr
+			}
+			checkQuota := func(step string, cpu *api.LinuxCPU) {
+				t.Helper()
+				if got, want := cpu.GetQuota(), tc.quota; got.GetValue() != want.GetValue() || (got == nil) != (want == nil) {
+					t.Errorf("%s: expected the quota to be left untouched; want: %v got: %v", step, want, got)
+				}
+			}
+			checkNoWrites := func(step string) {
+				t.Helper()
+				if len(fca.pod) != 0 || len(fca.ctr) != 0 {
+					t.Errorf("%s: expected no cfs quota writes; pods: %v containers: %v", step, fca.pod, fca.ctr)
+				}
+			}
+
+			if err := p.RunPodSandbox(sb); err != nil {
+				t.Fatal(err)
+			}
+			adjustment, _, err := p.CreateContainer(sb, newCtr())
+			if err != nil {
+				t.Fatal(err)
+			}
+			cpu := adjustment.GetLinux().GetResources().GetCpu()
+			if got := cpu.GetCpus(); got != "0-2" {
+				t.Errorf("unexpected cpus; want: %q got: %q", "0-2", got)
+			}
+			checkQuota("CreateContainer", cpu)
+			if err := p.PostCreateContainer(sb, newCtr()); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.StartContainer(sb, newCtr()); err != nil {
+				t.Fatal(err)
+			}
+			checkNoWrites("PostCreateContainer")
+
+			updates, err := p.UpdateContainer(sb, newCtr())
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkQuota("UpdateContainer", updates[0].GetLinux().GetResources().GetCpu())
+			if err := p.PostUpdateContainer(sb, newCtr()); err != nil {
+				t.Fatal(err)
+			}
+			checkNoWrites("UpdateContainer")
+
+			if err := p.UpdateMutualCPUs(e2ecpuset.MustParse("0,3")); err != nil {
+				t.Fatal(err)
+			}
+			if len(fs.updates) != 1 {
+				t.Fatalf("expected exactly one update, got: %d", len(fs.updates))
+			}
+			cpu = fs.updates[0].GetLinux().GetResources().GetCpu()
+			if got := cpu.GetCpus(); got != "0-3" {
+				t.Errorf("unexpected cpus after updating the mutual cpus; want: %q got: %q", "0-3", got)
+			}
+			checkQuota("UpdateMutualCPUs", cpu)
+			checkNoWrites("UpdateMutualCPUs")
+
+			updates, err = p.Synchronize([]*api.PodSandbox{sb}, []*api.Container{newCtr()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, u := range updates {
+				checkQuota("Synchronize", u.GetLinux().GetResources().GetCpu())
+			}
+			checkNoWrites("Synchronize")
+
+			if _, err := p.StopContainer(sb, newCtr()); err != nil {
+				t.Fatal(err)
+			}
+			checkNoWrites("StopContainer")
+		})
+	}
+}
+
+func TestUpdateContainerWithoutQuota(t *testing.T) {
+	fca := &fakeCgroupsAdapter{}
+	mutualCPUs := e2ecpuset.MustParse("0")
+	p := &Plugin{
+		MutualCPUs: &mutualCPUs,
+		Runtime:    cgroups.RuntimeCrio,
+		cgroups:    fca,
+	}
+	sb := makePodSandbox("test-sb")
+	ctr := makeContainer("test-ctr",
+		withLinuxResources("1,2", 200000),
+		withPeriod(100000),
+		withEnv(deviceplugin.EnvVarName+"=0"))
+	if _, _, err := p.CreateContainer(sb, ctr); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PostCreateContainer(sb, ctr); err != nil {
+		t.Fatal(err)
+	}
+
+	// the CPU Manager updates the cpus only
+	updated := makeContainer("test-ctr", withEnv(deviceplugin.EnvVarName+"=0"))
+	updated.Id = ctr.GetId()
+	updated.Linux.Resources = &api.LinuxResources{Cpu: &api.LinuxCPU{Cpus: "1,3"}}
+	updates, err := p.UpdateContainer(sb, updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu := updates[0].GetLinux().GetResources().GetCpu()
+	if got := cpu.GetCpus(); got != "0-1,3" {
+		t.Errorf("unexpected cpus; want: %q got: %q", "0-1,3", got)
+	}
+	// the tracked container keeps its quota and period
+	if got := cpu.GetQuota().GetValue(); got != 300000 {
+		t.Errorf("unexpected quota; want: %d got: %d", 300000, got)
+	}
+	if got := cpu.GetPeriod().GetValue(); got != 100000 {
+		t.Errorf("unexpected period; want: %d got: %d", 100000, got)
+	}
+	if err := p.PostUpdateContainer(sb, updated); err != nil {
+		t.Fatal(err)
+	}
+	if got := fca.pod[sb.GetLinux().GetCgroupParent()].Quota; got != 300000 {
+		t.Errorf("unexpected pod quota; want: %d got: %d", 300000, got)
+	}
+}
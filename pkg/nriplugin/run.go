@@ -0,0 +1,108 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/containerd/nri/pkg/stub"
+	"github.com/golang/glog"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/health"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/metrics"
+)
+
+const (
+	// connectionResetAfter is how long a connection has to last
+	// for the reconnection backoff to start over
+	connectionResetAfter = time.Minute
+)
+
+// reconnectBackoff for reconnecting to the runtime, e.g. when it restarts
+var reconnectBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Cap:      30 * time.Second,
+	// never give up
+	Steps: int(^uint(0) >> 1),
+}
+
+// Run connects the plugin to the runtime and reconnects with a backoff
+// whenever the connection is lost, until the context is done.
+// A stub can be started only once, so every connection gets a new stub.
+func (p *Plugin) Run(ctx context.Context) error {
+	backoff := reconnectBackoff
+	for {
+		start := time.Now()
+		err := p.getStub().Run(ctx)
+		health.SetReady(health.NRI, false)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if time.Since(start) > connectionResetAfter {
+			backoff = reconnectBackoff
+		}
+		delay := backoff.Step()
+		glog.Warningf("NRI connection lost: %v; reconnecting in %s", err, delay)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		s, err := p.newStub()
+		if err != nil {
+			return err
+		}
+		p.setStub(s)
+		metrics.NRIReconnects.Inc()
+	}
+}
+
+// newStub creates a stub for a new connection to the runtime
+func (p *Plugin) newStub() (stub.Stub, error) {
+	opts := append([]stub.Option{stub.WithOnClose(p.onClose)}, p.stubOpts...)
+	s, err := stub.New(p, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create plugin stub: %w", err)
+	}
+	return s, nil
+}
+
+// onClose keeps the process running when the connection is lost,
+// instead of the stub's default of exiting
+func (p *Plugin) onClose() {
+	glog.Warningf("NRI connection closed")
+	health.SetReady(health.NRI, false)
+}
+
+func (p *Plugin) getStub() stub.Stub {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Stub
+}
+
+func (p *Plugin) setStub(s stub.Stub) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Stub = s
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/containerd/nri/pkg/stub"
+)
+
+func TestRunReconnects(t *testing.T) {
+	defer func(b wait.Backoff) { reconnectBackoff = b }(reconnectBackoff)
+	reconnectBackoff = wait.Backoff{Duration: time.Millisecond, Steps: 100}
+
+	fs := &fakeStub{runErr: fmt.Errorf("connection lost")}
+	p := &Plugin{
+		Stub:     fs,
+		stubOpts: []stub.Option{stub.WithPluginName("test"), stub.WithPluginIdx("00")},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- p.Run(ctx)
+	}()
+
+	// the lost stub is replaced by a new one
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+		return p.getStub() != fs, nil
+	}); err != nil {
+		t.Fatalf("the stub was not replaced: %v", err)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run did not return once the context was done")
+	}
+}
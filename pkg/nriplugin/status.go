@@ -0,0 +1,68 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"sort"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/cgroups"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/status"
+)
+
+// NodeStatus returns the shared cpus status of the node
+func (p *Plugin) NodeStatus() status.NodeStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := status.NodeStatus{
+		CgroupMode: string(cgroups.Adapter.GetMode()),
+		Runtime:    string(p.Runtime),
+	}
+	if p.MutualCPUs != nil {
+		st.MutualCPUs = p.MutualCPUs.String()
+	}
+	for id, mc := range p.containers {
+		c := status.Container{
+			ID:        id,
+			Name:      mc.uniqueName,
+			LastError: mc.lastError,
+		}
+		if cpu := mc.resources.GetCpu(); cpu != nil {
+			c.CPUs = cpu.GetCpus()
+			c.Quota = cpu.GetQuota().GetValue()
+		}
+		st.Containers = append(st.Containers, c)
+	}
+	sort.Slice(st.Containers, func(i, j int) bool {
+		return st.Containers[i].Name < st.Containers[j].Name
+	})
+	return st
+}
+
+// setContainerError records the last error of applying the shared cpus to the container.
+// A nil error clears it.
+func (p *Plugin) setContainerError(ctrId string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	mc, ok := p.containers[ctrId]
+	if !ok {
+		return
+	}
+	mc.lastError = ""
+	if err != nil {
+		mc.lastError = err.Error()
+	}
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+
+	"github.com/containerd/nri/pkg/api"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/cgroups"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/status"
+)
+
+func TestNodeStatus(t *testing.T) {
+	cpus := cpuset.New(0, 1)
+	p := &Plugin{MutualCPUs: &cpus, Runtime: cgroups.RuntimeCrio}
+	for _, name := range []string{"ctr-b", "ctr-a"} {
+		p.trackContainer(name+"-id", &mutualContainer{
+			uniqueName: "ns/pod/" + name,
+			resources: &api.LinuxResources{
+				Cpu: &api.LinuxCPU{
+					Cpus:  "0-3",
+					Quota: &api.OptionalInt64{Value: 400000},
+				},
+			},
+			defaultPool: true,
+		})
+	}
+	p.setContainerError("ctr-b-id", fmt.Errorf("failed to set container cfs quota"))
+
+	want := status.NodeStatus{
+		MutualCPUs: "0-1",
+		CgroupMode: string(cgroups.Adapter.GetMode()),
+		Runtime:    string(cgroups.RuntimeCrio),
+		Containers: []status.Container{
+			{ID: "ctr-a-id", Name: "ns/pod/ctr-a", CPUs: "0-3", Quota: 400000},
+			{ID: "ctr-b-id", Name: "ns/pod/ctr-b", CPUs: "0-3", Quota: 400000, LastError: "failed to set container cfs quota"},
+		},
+	}
+	if got := p.NodeStatus(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected status; want: %+v got: %+v", want, got)
+	}
+
+	// a successful update clears the error
+	p.setContainerError("ctr-b-id", nil)
+	if got := p.NodeStatus().Containers[1].LastError; got != "" {
+		t.Fatalf("expected the error to be cleared, got: %q", got)
+	}
+}
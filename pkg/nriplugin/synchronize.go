@@ -0,0 +1,162 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+
+	"github.com/containerd/nri/pkg/api"
+	"github.com/golang/glog"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/cgroups"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/config"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/deviceplugin"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/health"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/metrics"
+)
+
+// Synchronize restores the shared cpus and cfs quota of the existing containers
+// that requested them, e.g. after the plugin (re)started or reconnected to the runtime.
+// Containers that are already up to date are only tracked.
+func (p *Plugin) Synchronize(pods []*api.PodSandbox, containers []*api.Container) ([]*api.ContainerUpdate, error) {
+	defer health.Begin("Synchronize")()
+	podByID := make(map[string]*api.PodSandbox, len(pods))
+	for _, pod := range pods {
+		podByID[pod.GetId()] = pod
+	}
+
+	// the pods' quotas account for all of their containers, as they are running now.
+	// Containers known from before keep their original quotas, since on a reconnect
+	// the runtime reports the quotas that were raised for the shared cpus.
+	known := p.syncMembers(pods, containers)
+	for _, ctr := range containers {
+		pod, ok := podByID[ctr.GetPodSandboxId()]
+		if !ok {
+			continue
+		}
+		quota := ctrCFSQuota(ctr.GetLinux().GetResources())
+		if known[ctr.GetId()] {
+			p.setMemberQuota(pod, ctr, quota, nil, false)
+		} else if ctr.GetState() != api.ContainerState_CONTAINER_STOPPED {
+			p.setMemberQuota(pod, ctr, quota, &quota, false)
+		}
+	}
+	before := make(map[string]cgroups.CFSQuota)
+	for _, pod := range pods {
+		before[pod.GetId()], _ = p.podCFSQuota(pod.GetId())
+	}
+
+	var updates []*api.ContainerUpdate
+	podUpdates := make(map[string][]*api.ContainerUpdate)
+	for _, ctr := range containers {
+		pod, ok := podByID[ctr.GetPodSandboxId()]
+		if !ok {
+			glog.Warningf("Synchronize: no pod %q found for container %q", ctr.GetPodSandboxId(), ctr.GetName())
+			continue
+		}
+		pools, devices := p.requestedPools(pod, ctr)
+		if len(pools) == 0 {
+			continue
+		}
+		p.trackDevices(pod, devices)
+
+		uniqueName := getCtrUniqueName(pod, ctr)
+		if ctr.GetState() == api.ContainerState_CONTAINER_CREATED &&
+			len(cpuTuningAnnotations(pod)) > 0 && p.cpuTuningPolicy() == config.CPUTuningExclusive {
+			glog.Infof("Synchronize: container %q gets the shared cpus once it started", uniqueName)
+			continue
+		}
+		cpu := ctr.GetLinux().GetResources().GetCpu()
+		if cpu == nil {
+			glog.Warningf("Synchronize: container %q has no cpu resources", uniqueName)
+			continue
+		}
+		oldCpus, oldQuota := cpu.GetCpus(), cpu.GetQuota().GetValue()
+		update, quota, err := p.sharedCPUsUpdate(pod, ctr, pools)
+		if err != nil {
+			glog.Errorf("Synchronize: container %q: %v", uniqueName, err)
+			continue
+		}
+		newCpus, newQuota := cpu.GetCpus(), cpu.GetQuota().GetValue()
+		if quota != nil {
+			var original *cgroups.CFSQuota
+			if !known[ctr.GetId()] {
+				original = p.originalCFSQuota(oldCpus, pools, cpu.GetPeriod().GetValue(), uniqueName)
+			}
+			p.setMemberQuota(pod, ctr, *quota, original, true)
+		}
+		if oldCpus == newCpus && oldQuota == newQuota {
+			glog.Infof("Synchronize: container %q is up to date; cpus %q quota %d", uniqueName, newCpus, newQuota)
+			continue
+		}
+		glog.Infof("Synchronize: container %q cpus %q -> %q, quota %d -> %d", uniqueName, oldCpus, newCpus, oldQuota, newQuota)
+		updates = append(updates, update)
+		podUpdates[pod.GetId()] = append(podUpdates[pod.GetId()], update)
+	}
+
+	// the pods' quotas must be raised before the containers',
+	// and the runtime applies the containers' updates only after we return.
+	// Lowered quotas, e.g. of shrunk pools or of shared containers that are gone,
+	// can be written only once the containers' quotas are lowered, and no PostUpdateContainer
+	// follows the updates of Synchronize, so the containers' quotas are written first.
+	for _, pod := range pods {
+		quota, managed := p.podCFSQuota(pod.GetId())
+		if !managed {
+			continue
+		}
+		if !quotaGrows(before[pod.GetId()], quota) {
+			p.setContainersCFSQuota(pod, podUpdates[pod.GetId()])
+		}
+		p.setPodCFSQuota(pod.GetLinux().GetCgroupParent(), quota)
+	}
+	metrics.NRIRequests.WithLabelValues("Synchronize", metrics.ResultSuccess).Inc()
+	glog.V(4).Infof("sending synchronize updates to runtime: %+v", updates)
+	return updates, nil
+}
+
+// setContainersCFSQuota writes the quotas of the pod's container updates ahead of the runtime,
+// along with their bursts. Failures are only logged, the runtime writes the quotas anyway.
+func (p *Plugin) setContainersCFSQuota(pod *api.PodSandbox, updates []*api.ContainerUpdate) {
+	for _, u := range updates {
+		cpu := u.GetLinux().GetResources().GetCpu()
+		if cpu.GetQuota() == nil {
+			continue
+		}
+		quota, ok := p.burstQuota(u.GetContainerId())
+		if !ok {
+			quota = cgroups.CFSQuota{Quota: cpu.GetQuota().GetValue(), Period: cpu.GetPeriod().GetValue()}
+		}
+		if err := p.getCgroupsAdapter().SetContainerCFSQuota(pod.GetLinux().GetCgroupParent(), u.GetContainerId(), p.getRuntime(), quota); err != nil {
+			glog.Errorf("Synchronize: failed to set container %q cfs quota: %v", u.GetContainerId(), err)
+		}
+	}
+}
+
+// originalCFSQuota returns the quota kubelet gave to a container the plugin did not know about,
+// which may be running with the shared cpus already, e.g. after the plugin restarted.
+// The quota covers the container's exclusive cpus only.
+func (p *Plugin) originalCFSQuota(cpus string, pools []deviceplugin.Pool, period uint64, uniqueName string) *cgroups.CFSQuota {
+	curCpus, err := cpuset.Parse(cpus)
+	if err != nil {
+		glog.Warningf("Synchronize: container %q: failed to parse cpuset %q: %v", uniqueName, cpus, err)
+		return nil
+	}
+	sharedCPUs, _, _ := sharedCPUsOf(pools)
+	exclusiveCpus := curCpus.Difference(sharedCPUs).Difference(p.getFormerMutualCPUs())
+	q := cfsQuotaFor(config.QuotaPolicyProportional, 0, exclusiveCpus, cpuset.New(), period)
+	return &q
+}
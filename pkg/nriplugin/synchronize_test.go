@@ -0,0 +1,140 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nriplugin
+
+import (
+	"testing"
+
+	"github.com/containerd/nri/pkg/api"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/cgroups"
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/deviceplugin"
+	e2ecpuset "github.com/openshift-kni/mixed-cpu-node-plugin/test/e2e/cpuset"
+)
+
+func TestSynchronize(t *testing.T) {
+	mutualCPUs := e2ecpuset.MustParse("0")
+	fca := &fakeCgroupsAdapter{}
+	p := &Plugin{
+		MutualCPUs: &mutualCPUs,
+		Runtime:    cgroups.RuntimeCrio,
+		cgroups:    fca,
+	}
+	sb := makePodSandbox("test-sb")
+	requested := makeContainer("requested",
+		withLinuxResources("1,2", 200000),
+		withPeriod(100000),
+		withEnv(deviceplugin.EnvVarName+"=0"),
+		withPodSandboxId(sb.GetId()))
+	// already running with the shared cpus
+	applied := makeContainer("applied",
+		withLinuxResources("0,3", 200000),
+		withPeriod(100000),
+		withEnv(deviceplugin.EnvVarName+"=0"),
+		withPodSandboxId(sb.GetId()))
+	notRequested := makeContainer("not-requested",
+		withLinuxResources("4", 100000),
+		withPeriod(100000),
+		withPodSandboxId(sb.GetId()))
+	orphan := makeContainer("orphan",
+		withLinuxResources("5", 100000),
+		withPeriod(100000),
+		withEnv(deviceplugin.EnvVarName+"=0"),
+		withPodSandboxId("missing"))
+
+	updates, err := p.Synchronize([]*api.PodSandbox{sb}, []*api.Container{requested, applied, notRequested, orphan})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the applied container is up to date, so only the requested one is updated
+	if len(updates) != 1 {
+		t.Fatalf("expected exactly one update, got: %d", len(updates))
+	}
+	u := updates[0]
+	if u.ContainerId != requested.GetId() {
+		t.Fatalf("unexpected container updated; want: %q, got: %q", requested.GetId(), u.ContainerId)
+	}
+	if got := u.Linux.Resources.Cpu.Cpus; got != "0-2" {
+		t.Errorf("unexpected cpus; want: %q got: %q", "0-2", got)
+	}
+	if got := u.Linux.Resources.Cpu.Quota.Value; got != 300000 {
+		t.Errorf("unexpected quota; want: %d got: %d", 300000, got)
+	}
+	// the pod's quota is the sum of all of its containers' quotas
+	if got := fca.pod[sb.GetLinux().GetCgroupParent()].Quota; got != 600000 {
+		t.Errorf("unexpected pod quota; want: %d got: %d", 600000, got)
+	}
+	if len(p.containers) != 2 {
+		t.Errorf("expected the synchronized containers to be tracked, got: %d", len(p.containers))
+	}
+}
+
+func TestSynchronizeLowersPodQuota(t *testing.T) {
+	mutualCPUs := e2ecpuset.MustParse("0,5")
+	fca := &fakeCgroupsAdapter{}
+	p := &Plugin{
+		MutualCPUs: &mutualCPUs,
+		Runtime:    cgroups.RuntimeCrio,
+		cgroups:    fca,
+	}
+	sb := makePodSandbox("test-sb")
+	sidecar := makeContainer("sidecar",
+		withLinuxResources("3", 100000),
+		withPeriod(100000),
+		withPodSandboxId(sb.GetId()))
+	shared := makeContainer("shared",
+		withLinuxResources("1,2", 200000),
+		withPeriod(100000),
+		withEnv(deviceplugin.EnvVarName+"=0"),
+		withPodSandboxId(sb.GetId()))
+	gone := makeContainer("gone",
+		withLinuxResources("4", 100000),
+		withPeriod(100000),
+		withEnv(deviceplugin.EnvVarName+"=0"),
+		withPodSandboxId(sb.GetId()))
+	if _, err := p.Synchronize([]*api.PodSandbox{sb}, []*api.Container{sidecar, shared, gone}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fca.pod[sb.GetLinux().GetCgroupParent()].Quota; got != 800000 {
+		t.Fatalf("unexpected pod quota; want: %d got: %d", 800000, got)
+	}
+
+	// while the plugin was disconnected, a container was removed
+	// and cpu 0 was dropped from the mutual cpus
+	mutualCPUs = e2ecpuset.MustParse("5")
+	p.formerMutualCPUs = e2ecpuset.MustParse("0")
+	running := makeContainer("shared",
+		withLinuxResources("0-2,5", 400000),
+		withPeriod(100000),
+		withEnv(shared.GetEnv()...),
+		withPodSandboxId(sb.GetId()))
+	running.Id = shared.GetId()
+	updates, err := p.Synchronize([]*api.PodSandbox{sb}, []*api.Container{sidecar, running})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 1 || updates[0].Linux.Resources.Cpu.Cpus != "1-2,5" {
+		t.Fatalf("expected the shared container to lose cpu 0, got: %+v", updates)
+	}
+	// the pod's quota is lowered along with the shrunk pool and the gone container,
+	// once the containers' quotas were lowered
+	if got := fca.ctr[shared.GetId()].Quota; got != 300000 {
+		t.Errorf("unexpected container quota; want: %d got: %d", 300000, got)
+	}
+	if got := fca.pod[sb.GetLinux().GetCgroupParent()].Quota; got != 400000 {
+		t.Errorf("unexpected pod quota; want: %d got: %d", 400000, got)
+	}
+}
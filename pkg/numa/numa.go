@@ -0,0 +1,103 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package numa
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+)
+
+const (
+	SysfsRoot = "/sys"
+	nodesDir  = "devices/system/node"
+)
+
+// Topology maps NUMA node ids to the cpus that belong to them
+type Topology map[int]cpuset.CPUSet
+
+// Discover reads the NUMA topology under the sysfs root
+func Discover(sysfsRoot string) (Topology, error) {
+	dir := filepath.Join(sysfsRoot, nodesDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read NUMA nodes directory: %w", err)
+	}
+
+	topo := make(Topology)
+	for _, entry := range entries {
+		id, ok := nodeID(entry.Name())
+		if !ok {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name(), "cpulist"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read NUMA node %d cpus: %w", id, err)
+		}
+		cpus, err := cpuset.Parse(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse NUMA node %d cpus: %w", id, err)
+		}
+		topo[id] = cpus
+	}
+	if len(topo) == 0 {
+		return nil, fmt.Errorf("no NUMA nodes found under %q", dir)
+	}
+	return topo, nil
+}
+
+// NodesOf returns the sorted ids of the NUMA nodes that hold any of the cpus
+func (t Topology) NodesOf(cpus cpuset.CPUSet) []int {
+	var nodes []int
+	for id, nodeCPUs := range t {
+		if !nodeCPUs.Intersection(cpus).IsEmpty() {
+			nodes = append(nodes, id)
+		}
+	}
+	sort.Ints(nodes)
+	return nodes
+}
+
+// CPUsOf returns the cpus of the NUMA nodes
+func (t Topology) CPUsOf(nodes ...int) cpuset.CPUSet {
+	cpus := cpuset.New()
+	for _, id := range nodes {
+		cpus = cpus.Union(t[id])
+	}
+	return cpus
+}
+
+// LocalCPUs returns the shared cpus that are on the same NUMA nodes as the exclusive cpus
+func (t Topology) LocalCPUs(shared, exclusive cpuset.CPUSet) cpuset.CPUSet {
+	return shared.Intersection(t.CPUsOf(t.NodesOf(exclusive)...))
+}
+
+func nodeID(name string) (int, bool) {
+	if !strings.HasPrefix(name, "node") {
+		return 0, false
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(name, "node"))
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package numa
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+)
+
+func TestDiscover(t *testing.T) {
+	root := t.TempDir()
+	makeFakeNode(t, root, "node0", "0-3,8-11\n")
+	makeFakeNode(t, root, "node1", "4-7,12-15\n")
+	// not a NUMA node directory
+	if err := os.MkdirAll(filepath.Join(root, nodesDir, "power"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	topo, err := Discover(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topo) != 2 {
+		t.Fatalf("unexpected number of NUMA nodes; want: 2 got: %d", len(topo))
+	}
+	if topo[0].String() != "0-3,8-11" || topo[1].String() != "4-7,12-15" {
+		t.Fatalf("unexpected topology %v", topo)
+	}
+
+	if _, err := Discover(t.TempDir()); err == nil {
+		t.Fatalf("expected error for missing NUMA nodes")
+	}
+}
+
+func TestLocalCPUs(t *testing.T) {
+	topo := Topology{
+		0: cpuset.New(0, 1, 2, 3),
+		1: cpuset.New(4, 5, 6, 7),
+	}
+	testCases := []struct {
+		name      string
+		shared    cpuset.CPUSet
+		exclusive cpuset.CPUSet
+		wantNodes []int
+		want      cpuset.CPUSet
+	}{
+		{
+			name:      "exclusive on node0",
+			shared:    cpuset.New(0, 4),
+			exclusive: cpuset.New(2, 3),
+			wantNodes: []int{0},
+			want:      cpuset.New(0),
+		},
+		{
+			name:      "exclusive on node1",
+			shared:    cpuset.New(0, 4),
+			exclusive: cpuset.New(6),
+			wantNodes: []int{1},
+			want:      cpuset.New(4),
+		},
+		{
+			name:      "exclusive across nodes",
+			shared:    cpuset.New(0, 4),
+			exclusive: cpuset.New(3, 5),
+			wantNodes: []int{0, 1},
+			want:      cpuset.New(0, 4),
+		},
+		{
+			name:      "no local shared cpus",
+			shared:    cpuset.New(0),
+			exclusive: cpuset.New(5),
+			wantNodes: []int{1},
+			want:      cpuset.New(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := topo.NodesOf(tc.exclusive); !reflect.DeepEqual(got, tc.wantNodes) {
+				t.Errorf("unexpected nodes; want: %v got: %v", tc.wantNodes, got)
+			}
+			if got := topo.LocalCPUs(tc.shared, tc.exclusive); !got.Equals(tc.want) {
+				t.Errorf("unexpected local cpus; want: %q got: %q", tc.want.String(), got.String())
+			}
+		})
+	}
+}
+
+func makeFakeNode(t *testing.T, root, node, cpulist string) {
+	t.Helper()
+	dir := filepath.Join(root, nodesDir, node)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cpulist"), []byte(cpulist), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
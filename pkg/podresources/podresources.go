@@ -0,0 +1,120 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package podresources
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	podresourcesapi "k8s.io/kubelet/pkg/apis/podresources/v1"
+)
+
+const (
+	// SocketPath is the kubelet PodResources API endpoint
+	SocketPath = "/var/lib/kubelet/pod-resources/kubelet.sock"
+	// requestTimeout bounds every call to kubelet
+	requestTimeout = 10 * time.Second
+	// maxMsgSize is large enough for nodes with many pods
+	maxMsgSize = 16 * 1024 * 1024
+)
+
+// Client queries kubelet for the devices that are assigned to pods
+type Client struct {
+	client podresourcesapi.PodResourcesListerClient
+	conn   *grpc.ClientConn
+}
+
+// New returns a client of the PodResources API served on the unix socket.
+// The connection is established lazily, so kubelet does not have to be up yet.
+func New(socketPath string) (*Client, error) {
+	conn, err := grpc.Dial("unix://"+socketPath,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMsgSize)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to pod resources socket %q: %w", socketPath, err)
+	}
+	return &Client{
+		client: podresourcesapi.NewPodResourcesListerClient(conn),
+		conn:   conn,
+	}, nil
+}
+
+// Close closes the connection to kubelet
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// ContainerDevices returns the ids of the devices assigned to the container,
+// keyed by the fully qualified resource name.
+// A nil map is returned when kubelet does not report the container.
+func (c *Client) ContainerDevices(ctx context.Context, namespace, podName, containerName string) (map[string][]string, error) {
+	pods, err := c.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods {
+		if pod.GetNamespace() != namespace || pod.GetName() != podName {
+			continue
+		}
+		for _, ctr := range pod.GetContainers() {
+			if ctr.GetName() != containerName {
+				continue
+			}
+			devices := make(map[string][]string)
+			for _, dev := range ctr.GetDevices() {
+				devices[dev.GetResourceName()] = append(devices[dev.GetResourceName()], dev.GetDeviceIds()...)
+			}
+			return devices, nil
+		}
+	}
+	return nil, nil
+}
+
+// AllocatedDevices returns the sorted ids of the resource's devices
+// that are assigned to any container on the node
+func (c *Client) AllocatedDevices(ctx context.Context, resourceName string) ([]string, error) {
+	pods, err := c.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, pod := range pods {
+		for _, ctr := range pod.GetContainers() {
+			for _, dev := range ctr.GetDevices() {
+				if dev.GetResourceName() == resourceName {
+					ids = append(ids, dev.GetDeviceIds()...)
+				}
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (c *Client) list(ctx context.Context) ([]*podresourcesapi.PodResources, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	resp, err := c.client.List(ctx, &podresourcesapi.ListPodResourcesRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pod resources: %w", err)
+	}
+	return resp.GetPodResources(), nil
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package podresources
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	podresourcesapi "k8s.io/kubelet/pkg/apis/podresources/v1"
+)
+
+// fakeServer serves a fixed list of pod resources
+type fakeServer struct {
+	podresourcesapi.UnimplementedPodResourcesListerServer
+	pods []*podresourcesapi.PodResources
+}
+
+func (f *fakeServer) List(ctx context.Context, req *podresourcesapi.ListPodResourcesRequest) (*podresourcesapi.ListPodResourcesResponse, error) {
+	return &podresourcesapi.ListPodResourcesResponse{PodResources: f.pods}, nil
+}
+
+func TestClient(t *testing.T) {
+	pods := []*podresourcesapi.PodResources{
+		{
+			Name:      "pod1",
+			Namespace: "ns1",
+			Containers: []*podresourcesapi.ContainerResources{
+				{
+					Name: "ctr1",
+					Devices: []*podresourcesapi.ContainerDevices{
+						{ResourceName: "openshift.io/mutualcpu", DeviceIds: []string{"3"}},
+						{ResourceName: "openshift.io/mutualcpu-io", DeviceIds: []string{"0"}},
+					},
+				},
+				{
+					Name: "ctr2",
+					Devices: []*podresourcesapi.ContainerDevices{
+						{ResourceName: "vendor.com/nic", DeviceIds: []string{"a"}},
+					},
+				},
+			},
+		},
+		{
+			Name:      "pod1",
+			Namespace: "ns2",
+			Containers: []*podresourcesapi.ContainerResources{
+				{
+					Name: "ctr1",
+					Devices: []*podresourcesapi.ContainerDevices{
+						{ResourceName: "openshift.io/mutualcpu", DeviceIds: []string{"1"}},
+					},
+				},
+			},
+		},
+	}
+	c := startFakeServer(t, pods)
+
+	devices, err := c.ContainerDevices(context.Background(), "ns1", "pod1", "ctr1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"openshift.io/mutualcpu":    {"3"},
+		"openshift.io/mutualcpu-io": {"0"},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("unexpected container devices; want: %v got: %v", want, devices)
+	}
+
+	devices, err = c.ContainerDevices(context.Background(), "ns1", "pod1", "ctr2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices["openshift.io/mutualcpu"]) != 0 {
+		t.Errorf("expected no mutualcpu devices, got: %v", devices)
+	}
+
+	devices, err = c.ContainerDevices(context.Background(), "ns1", "missing", "ctr1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if devices != nil {
+		t.Errorf("expected nil devices for a missing pod, got: %v", devices)
+	}
+
+	ids, err := c.AllocatedDevices(context.Background(), "openshift.io/mutualcpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1", "3"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("unexpected allocated devices; want: %v got: %v", want, ids)
+	}
+}
+
+func startFakeServer(t *testing.T, pods []*podresourcesapi.PodResources) *Client {
+	socket := filepath.Join(t.TempDir(), "kubelet.sock")
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	podresourcesapi.RegisterPodResourcesListerServer(server, &fakeServer{pods: pods})
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	t.Cleanup(server.Stop)
+
+	c, err := New(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// ModeCR reports the status in a MixedCPUNodeStatus object named after the node
+	ModeCR = "cr"
+	// ModeAnnotations reports the status in the node's annotations
+	ModeAnnotations = "annotations"
+
+	Group    = "mixedcpus.openshift.io"
+	Version  = "v1alpha1"
+	Kind     = "MixedCPUNodeStatus"
+	Resource = "mixedcpunodestatuses"
+
+	AnnotationMutualCPUs = Group + "/mutual-cpus"
+	AnnotationCgroupMode = Group + "/cgroup-mode"
+	AnnotationRuntime    = Group + "/runtime"
+	AnnotationContainers = Group + "/containers"
+
+	reportPeriod = 10 * time.Second
+)
+
+// GroupVersionKind of the MixedCPUNodeStatus objects
+var GroupVersionKind = schema.GroupVersionKind{Group: Group, Version: Version, Kind: Kind}
+
+// NodeStatus is the shared cpus status of a node
+type NodeStatus struct {
+	MutualCPUs string `json:"mutualCPUs"`
+	CgroupMode string `json:"cgroupMode"`
+	Runtime    string `json:"runtime,omitempty"`
+	// Containers are the containers that are running with shared cpus
+	Containers []Container `json:"containers,omitempty"`
+}
+
+// Container is a container that is running with shared cpus
+type Container struct {
+	ID string `json:"id"`
+	// Name is in the format of <namespace>/<pod>/<container>
+	Name  string `json:"name"`
+	CPUs  string `json:"cpus"`
+	Quota int64  `json:"quota"`
+	// LastError is the last error of applying the shared cpus to the container, if any
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Source provides the current status of the node
+type Source interface {
+	NodeStatus() NodeStatus
+}
+
+// Reporter publishes the node status, so it is visible in the cluster
+type Reporter struct {
+	cli      client.Client
+	nodeName string
+	mode     string
+	source   Source
+	// last is the last status that was reported successfully
+	last *NodeStatus
+}
+
+// New returns a reporter that publishes the status of the node in the given mode
+func New(cli client.Client, nodeName, mode string, source Source) (*Reporter, error) {
+	if mode != ModeCR && mode != ModeAnnotations {
+		return nil, fmt.Errorf("unsupported node status mode %q, expected %q or %q", mode, ModeCR, ModeAnnotations)
+	}
+	if nodeName == "" {
+		return nil, fmt.Errorf("node name is required for reporting the node status")
+	}
+	return &Reporter{
+		cli:      cli,
+		nodeName: nodeName,
+		mode:     mode,
+		source:   source,
+	}, nil
+}
+
+// Run reports the status periodically until the context is done.
+// Failures are logged and retried on the next period.
+func (r *Reporter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(reportPeriod)
+	defer ticker.Stop()
+	for {
+		if err := r.Report(ctx); err != nil {
+			glog.Errorf("failed to report node %q status: %v", r.nodeName, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Report publishes the current status, unless it was reported already
+func (r *Reporter) Report(ctx context.Context) error {
+	st := r.source.NodeStatus()
+	if r.last != nil && reflect.DeepEqual(*r.last, st) {
+		return nil
+	}
+	var err error
+	switch r.mode {
+	case ModeCR:
+		err = r.reportCR(ctx, st)
+	case ModeAnnotations:
+		err = r.reportAnnotations(ctx, st)
+	}
+	if err != nil {
+		return err
+	}
+	r.last = &st
+	return nil
+}
+
+func (r *Reporter) reportAnnotations(ctx context.Context, st NodeStatus) error {
+	node := &corev1.Node{}
+	if err := r.cli.Get(ctx, client.ObjectKey{Name: r.nodeName}, node); err != nil {
+		return fmt.Errorf("failed to get node: %w", err)
+	}
+	containers, err := json.Marshal(st.Containers)
+	if err != nil {
+		return err
+	}
+	patch := client.MergeFrom(node.DeepCopy())
+	if node.Annotations == nil {
+		node.Annotations = make(map[string]string)
+	}
+	node.Annotations[AnnotationMutualCPUs] = st.MutualCPUs
+	node.Annotations[AnnotationCgroupMode] = st.CgroupMode
+	node.Annotations[AnnotationRuntime] = st.Runtime
+	node.Annotations[AnnotationContainers] = string(containers)
+	if err := r.cli.Patch(ctx, node, patch); err != nil {
+		return fmt.Errorf("failed to patch node annotations: %w", err)
+	}
+	return nil
+}
+
+func (r *Reporter) reportCR(ctx context.Context, st NodeStatus) error {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(GroupVersionKind)
+	err := r.cli.Get(ctx, client.ObjectKey{Name: r.nodeName}, obj)
+	if apierrors.IsNotFound(err) {
+		if obj, err = r.createCR(ctx); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to get %s %q: %w", Kind, r.nodeName, err)
+	}
+
+	status, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&st)
+	if err != nil {
+		return err
+	}
+	obj.Object["status"] = status
+	if err := r.cli.Status().Update(ctx, obj); err != nil {
+		return fmt.Errorf("failed to update %s %q status: %w", Kind, r.nodeName, err)
+	}
+	return nil
+}
+
+// createCR creates the node's status object, owned by the node,
+// so it is garbage collected once the node is deleted.
+func (r *Reporter) createCR(ctx context.Context) (*unstructured.Unstructured, error) {
+	node := &corev1.Node{}
+	if err := r.cli.Get(ctx, client.ObjectKey{Name: r.nodeName}, node); err != nil {
+		return nil, fmt.Errorf("failed to get node: %w", err)
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(GroupVersionKind)
+	obj.SetName(r.nodeName)
+	obj.SetOwnerReferences([]metav1.OwnerReference{
+		{
+			APIVersion: "v1",
+			Kind:       "Node",
+			Name:       node.Name,
+			UID:        node.UID,
+		},
+	})
+	if err := unstructured.SetNestedField(obj.Object, r.nodeName, "spec", "nodeName"); err != nil {
+		return nil, err
+	}
+	if err := r.cli.Create(ctx, obj); err != nil {
+		return nil, fmt.Errorf("failed to create %s %q: %w", Kind, r.nodeName, err)
+	}
+	glog.Infof("created %s %q", Kind, r.nodeName)
+	return obj, nil
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const nodeName = "worker-0"
+
+type fakeSource struct {
+	status NodeStatus
+}
+
+func (f *fakeSource) NodeStatus() NodeStatus {
+	return f.status
+}
+
+func newFakeClient(t *testing.T) client.Client {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName, UID: "1234"}}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+}
+
+var sampleStatus = NodeStatus{
+	MutualCPUs: "0-1",
+	CgroupMode: "cgroupv2UnifiedMode",
+	Runtime:    "crio",
+	Containers: []Container{
+		{ID: "abc", Name: "ns/pod/ctr", CPUs: "0-3", Quota: 400000, LastError: "failed to set container cfs quota"},
+	},
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(nil, nodeName, "configmap", &fakeSource{}); err == nil {
+		t.Errorf("expected an error for an unsupported mode")
+	}
+	if _, err := New(nil, "", ModeCR, &fakeSource{}); err == nil {
+		t.Errorf("expected an error for a missing node name")
+	}
+}
+
+func TestReportAnnotations(t *testing.T) {
+	cli := newFakeClient(t)
+	src := &fakeSource{status: sampleStatus}
+	r, err := New(cli, nodeName, ModeAnnotations, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Report(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	node := &corev1.Node{}
+	if err := cli.Get(context.Background(), client.ObjectKey{Name: nodeName}, node); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		AnnotationMutualCPUs: "0-1",
+		AnnotationCgroupMode: "cgroupv2UnifiedMode",
+		AnnotationRuntime:    "crio",
+	}
+	for k, v := range want {
+		if got := node.Annotations[k]; got != v {
+			t.Errorf("unexpected annotation %q; want: %q got: %q", k, v, got)
+		}
+	}
+	var containers []Container
+	if err := json.Unmarshal([]byte(node.Annotations[AnnotationContainers]), &containers); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(containers, sampleStatus.Containers) {
+		t.Errorf("unexpected containers; want: %+v got: %+v", sampleStatus.Containers, containers)
+	}
+}
+
+func TestReportCR(t *testing.T) {
+	cli := newFakeClient(t)
+	src := &fakeSource{status: sampleStatus}
+	r, err := New(cli, nodeName, ModeCR, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Report(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// the status changes, so the existing object is updated
+	src.status.MutualCPUs = "0-2"
+	src.status.Containers = nil
+	if err := r.Report(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(GroupVersionKind)
+	if err := cli.Get(context.Background(), client.ObjectKey{Name: nodeName}, obj); err != nil {
+		t.Fatal(err)
+	}
+	if owners := obj.GetOwnerReferences(); len(owners) != 1 || owners[0].Kind != "Node" || owners[0].UID != "1234" {
+		t.Errorf("expected the object to be owned by the node, got: %+v", owners)
+	}
+	if got, _, _ := unstructured.NestedString(obj.Object, "spec", "nodeName"); got != nodeName {
+		t.Errorf("unexpected spec.nodeName; want: %q got: %q", nodeName, got)
+	}
+	if got, _, _ := unstructured.NestedString(obj.Object, "status", "mutualCPUs"); got != "0-2" {
+		t.Errorf("unexpected status.mutualCPUs; want: %q got: %q", "0-2", got)
+	}
+	if _, found, _ := unstructured.NestedSlice(obj.Object, "status", "containers"); found {
+		t.Errorf("expected no containers in the status")
+	}
+}
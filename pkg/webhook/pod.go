@@ -0,0 +1,177 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/openshift-kni/mixed-cpu-node-plugin/pkg/deviceplugin"
+)
+
+// InjectAnnotation lists the containers of the pod, comma separated,
+// that get the mutual cpus device injected by the webhook
+const InjectAnnotation = "mixedcpus.openshift.io/inject-shared-cpus"
+
+// isSharedCPUsResource checks whether the resource is the mutual cpus device, or a pool's device
+func isSharedCPUsResource(name corev1.ResourceName) bool {
+	return string(name) == deviceplugin.MutualCPUDeviceName ||
+		strings.HasPrefix(string(name), deviceplugin.MutualCPUDeviceName+"-")
+}
+
+// sharedCPUsResources returns the shared cpus devices the container requests
+func sharedCPUsResources(ctr *corev1.Container) []corev1.ResourceName {
+	seen := make(map[corev1.ResourceName]bool)
+	for _, list := range []corev1.ResourceList{ctr.Resources.Limits, ctr.Resources.Requests} {
+		for name := range list {
+			if isSharedCPUsResource(name) {
+				seen[name] = true
+			}
+		}
+	}
+	var names []corev1.ResourceName
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// Validate rejects pods whose containers request the shared cpus but would not get exclusive cpus.
+// Exclusive cpus are given by the CPU Manager only to containers of Guaranteed pods with integer cpus.
+func Validate(pod *corev1.Pod) error {
+	var requesting []string
+	for i := range pod.Spec.Containers {
+		ctr := &pod.Spec.Containers[i]
+		if len(sharedCPUsResources(ctr)) == 0 {
+			continue
+		}
+		requesting = append(requesting, ctr.Name)
+		cpus := ctr.Resources.Limits.Cpu()
+		if cpus.IsZero() || cpus.MilliValue()%1000 != 0 {
+			return fmt.Errorf("container %q requests shared cpus, so its cpu limit must be a positive integer to get exclusive cpus, got %q",
+				ctr.Name, cpus.String())
+		}
+	}
+	if len(requesting) == 0 {
+		return nil
+	}
+	if err := guaranteed(pod); err != nil {
+		return fmt.Errorf("containers %s request shared cpus, so the pod must be of Guaranteed QoS to get exclusive cpus: %w",
+			strings.Join(requesting, ", "), err)
+	}
+	return nil
+}
+
+// guaranteed checks that the cpu and memory requests of all the containers are equal to their limits
+func guaranteed(pod *corev1.Pod) error {
+	ctrs := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, ctr := range ctrs {
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			limit, ok := ctr.Resources.Limits[name]
+			if !ok || limit.IsZero() {
+				return fmt.Errorf("container %q has no %s limit", ctr.Name, name)
+			}
+			// requests default to the limits
+			if request, ok := ctr.Resources.Requests[name]; ok && request.Cmp(limit) != 0 {
+				return fmt.Errorf("container %q %s request %q differs from its limit %q", ctr.Name, name, request.String(), limit.String())
+			}
+		}
+	}
+	return nil
+}
+
+// patchOperation is a JSON patch operation
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Mutate returns the JSON patch that normalizes the shared cpus devices to exactly one per container,
+// since a single device is enough for getting the shared cpus.
+// When inject is set, the containers listed in InjectAnnotation get the mutual cpus device as well.
+func Mutate(pod *corev1.Pod, inject bool) []patchOperation {
+	injected := make(map[string]bool)
+	if inject {
+		for _, name := range strings.Split(pod.Annotations[InjectAnnotation], ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				injected[name] = true
+			}
+		}
+	}
+
+	one := resource.MustParse("1")
+	var patch []patchOperation
+	for i := range pod.Spec.Containers {
+		ctr := &pod.Spec.Containers[i]
+		names := sharedCPUsResources(ctr)
+		if injected[ctr.Name] && len(names) == 0 {
+			names = append(names, corev1.ResourceName(deviceplugin.MutualCPUDeviceName))
+		}
+		if len(names) == 0 {
+			continue
+		}
+		resourcesPath := fmt.Sprintf("/spec/containers/%d/resources", i)
+		patch = append(patch, normalize(resourcesPath+"/limits", ctr.Resources.Limits, names, one)...)
+		// extended resources' requests must be equal to their limits, so they are normalized only when set
+		var requested []corev1.ResourceName
+		for _, name := range names {
+			if _, ok := ctr.Resources.Requests[name]; ok {
+				requested = append(requested, name)
+			}
+		}
+		patch = append(patch, normalize(resourcesPath+"/requests", ctr.Resources.Requests, requested, one)...)
+	}
+	return patch
+}
+
+// normalize returns the operations that set the resources of the list at path to the quantity
+func normalize(path string, list corev1.ResourceList, names []corev1.ResourceName, q resource.Quantity) []patchOperation {
+	if len(names) == 0 {
+		return nil
+	}
+	if list == nil {
+		value := make(map[string]string)
+		for _, name := range names {
+			value[string(name)] = q.String()
+		}
+		return []patchOperation{{Op: "add", Path: path, Value: value}}
+	}
+	var patch []patchOperation
+	for _, name := range names {
+		cur, ok := list[name]
+		if ok && cur.Cmp(q) == 0 {
+			continue
+		}
+		op := "add"
+		if ok {
+			op = "replace"
+		}
+		patch = append(patch, patchOperation{Op: op, Path: path + "/" + escape(string(name)), Value: q.String()})
+	}
+	return patch
+}
+
+// escape escapes a JSON pointer reference token
+func escape(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
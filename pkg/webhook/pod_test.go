@@ -0,0 +1,230 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	mutualCPU = corev1.ResourceName("openshift.io/mutualcpu")
+	ioPool    = corev1.ResourceName("openshift.io/mutualcpu-io")
+)
+
+func makeContainer(name string, limits, requests map[corev1.ResourceName]string) corev1.Container {
+	toList := func(m map[corev1.ResourceName]string) corev1.ResourceList {
+		if m == nil {
+			return nil
+		}
+		list := corev1.ResourceList{}
+		for k, v := range m {
+			list[k] = resource.MustParse(v)
+		}
+		return list
+	}
+	return corev1.Container{
+		Name: name,
+		Resources: corev1.ResourceRequirements{
+			Limits:   toList(limits),
+			Requests: toList(requests),
+		},
+	}
+}
+
+func makePod(ctrs ...corev1.Container) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "ns"},
+		Spec:       corev1.PodSpec{Containers: ctrs},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		pod     *corev1.Pod
+		wantErr string
+	}{
+		{
+			name: "guaranteed with integer cpus",
+			pod: makePod(
+				makeContainer("app", map[corev1.ResourceName]string{"cpu": "2", "memory": "100M", mutualCPU: "1"}, nil),
+				makeContainer("sidecar", map[corev1.ResourceName]string{"cpu": "100m", "memory": "10M"}, nil),
+			),
+		},
+		{
+			name: "no shared cpus",
+			pod:  makePod(makeContainer("app", map[corev1.ResourceName]string{"cpu": "500m"}, nil)),
+		},
+		{
+			name:    "fractional cpus",
+			pod:     makePod(makeContainer("app", map[corev1.ResourceName]string{"cpu": "1500m", "memory": "100M", ioPool: "1"}, nil)),
+			wantErr: `container "app" requests shared cpus, so its cpu limit must be a positive integer`,
+		},
+		{
+			name:    "no cpu limit",
+			pod:     makePod(makeContainer("app", map[corev1.ResourceName]string{"memory": "100M", mutualCPU: "1"}, nil)),
+			wantErr: `its cpu limit must be a positive integer to get exclusive cpus, got "0"`,
+		},
+		{
+			name: "burstable",
+			pod: makePod(makeContainer("app",
+				map[corev1.ResourceName]string{"cpu": "2", "memory": "100M", mutualCPU: "1"},
+				map[corev1.ResourceName]string{"cpu": "1", "memory": "100M"})),
+			wantErr: `the pod must be of Guaranteed QoS to get exclusive cpus: container "app" cpu request "1" differs from its limit "2"`,
+		},
+		{
+			name: "other container without memory limit",
+			pod: makePod(
+				makeContainer("app", map[corev1.ResourceName]string{"cpu": "2", "memory": "100M", mutualCPU: "1"}, nil),
+				makeContainer("sidecar", map[corev1.ResourceName]string{"cpu": "1"}, nil),
+			),
+			wantErr: `container "sidecar" has no memory limit`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(tc.pod)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMutate(t *testing.T) {
+	testCases := []struct {
+		name        string
+		pod         *corev1.Pod
+		inject      bool
+		annotations map[string]string
+		want        []corev1.ResourceRequirements
+	}{
+		{
+			name: "already normalized",
+			pod:  makePod(makeContainer("app", map[corev1.ResourceName]string{"cpu": "2", mutualCPU: "1"}, nil)),
+			want: []corev1.ResourceRequirements{
+				{Limits: corev1.ResourceList{"cpu": resource.MustParse("2"), mutualCPU: resource.MustParse("1")}},
+			},
+		},
+		{
+			name: "more than one device",
+			pod: makePod(makeContainer("app",
+				map[corev1.ResourceName]string{"cpu": "2", mutualCPU: "3", ioPool: "2"},
+				map[corev1.ResourceName]string{"cpu": "2", mutualCPU: "3"})),
+			want: []corev1.ResourceRequirements{
+				{
+					Limits:   corev1.ResourceList{"cpu": resource.MustParse("2"), mutualCPU: resource.MustParse("1"), ioPool: resource.MustParse("1")},
+					Requests: corev1.ResourceList{"cpu": resource.MustParse("2"), mutualCPU: resource.MustParse("1")},
+				},
+			},
+		},
+		{
+			name: "injected by annotation",
+			pod: makePod(
+				makeContainer("app", map[corev1.ResourceName]string{"cpu": "2"}, nil),
+				makeContainer("sidecar", nil, nil),
+				makeContainer("other", map[corev1.ResourceName]string{"cpu": "1"}, nil),
+			),
+			inject:      true,
+			annotations: map[string]string{InjectAnnotation: "app, sidecar"},
+			want: []corev1.ResourceRequirements{
+				{Limits: corev1.ResourceList{"cpu": resource.MustParse("2"), mutualCPU: resource.MustParse("1")}},
+				{Limits: corev1.ResourceList{mutualCPU: resource.MustParse("1")}},
+				{Limits: corev1.ResourceList{"cpu": resource.MustParse("1")}},
+			},
+		},
+		{
+			name:        "injection disabled",
+			pod:         makePod(makeContainer("app", map[corev1.ResourceName]string{"cpu": "2"}, nil)),
+			annotations: map[string]string{InjectAnnotation: "app"},
+			want: []corev1.ResourceRequirements{
+				{Limits: corev1.ResourceList{"cpu": resource.MustParse("2")}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.pod.Annotations = tc.annotations
+			got := applyPatch(t, tc.pod, Mutate(tc.pod, tc.inject))
+			for i, want := range tc.want {
+				if !reflect.DeepEqual(normalizeList(got.Spec.Containers[i].Resources), normalizeList(want)) {
+					t.Errorf("container %q unexpected resources; want: %+v got: %+v",
+						got.Spec.Containers[i].Name, want, got.Spec.Containers[i].Resources)
+				}
+			}
+		})
+	}
+}
+
+func applyPatch(t *testing.T, pod *corev1.Pod, patch []patchOperation) *corev1.Pod {
+	t.Helper()
+	if len(patch) == 0 {
+		return pod
+	}
+	podData, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchData, err := json.Marshal(patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := jsonpatch.DecodePatch(patchData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := p.Apply(podData)
+	if err != nil {
+		t.Fatalf("failed to apply patch %s: %v", patchData, err)
+	}
+	got := &corev1.Pod{}
+	if err := json.Unmarshal(patched, got); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+// normalizeList renders the quantities, so they can be compared regardless of their internal representation
+func normalizeList(r corev1.ResourceRequirements) map[string]map[corev1.ResourceName]string {
+	out := make(map[string]map[corev1.ResourceName]string)
+	for kind, list := range map[string]corev1.ResourceList{"limits": r.Limits, "requests": r.Requests} {
+		if len(list) == 0 {
+			continue
+		}
+		out[kind] = make(map[corev1.ResourceName]string)
+		for name, q := range list {
+			out[kind][name] = q.String()
+		}
+	}
+	return out
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package webhook admits the pods that request the shared cpus.
+// The mutating webhook normalizes the shared cpus devices, and the validating webhook
+// rejects the pods whose containers would not get exclusive cpus along with the shared cpus.
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/golang/glog"
+)
+
+const (
+	MutatePath   = "/mutate"
+	ValidatePath = "/validate"
+
+	// maxBodySize limits the size of the admission reviews
+	maxBodySize = 3 * 1024 * 1024
+)
+
+// Server serves the mutating and validating webhooks
+type Server struct {
+	// Inject enables injecting the mutual cpus device into the containers listed in InjectAnnotation
+	Inject bool
+}
+
+// Handler returns the handler of the webhooks
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(MutatePath, serve(s.mutate))
+	mux.Handle(ValidatePath, serve(s.validate))
+	return mux
+}
+
+// ListenAndServeTLS serves the webhooks on the address with the certificate and key files
+func (s *Server) ListenAndServeTLS(addr, certFile, keyFile string) error {
+	srv := &http.Server{Addr: addr, Handler: s.Handler()}
+	return srv.ListenAndServeTLS(certFile, keyFile)
+}
+
+func (s *Server) mutate(pod *corev1.Pod) *admissionv1.AdmissionResponse {
+	patch := Mutate(pod, s.Inject)
+	if len(patch) == 0 {
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return errorResponse(http.StatusInternalServerError, err)
+	}
+	glog.V(4).Infof("mutating pod %s/%s: %s", pod.Namespace, podName(pod), data)
+	pt := admissionv1.PatchTypeJSONPatch
+	return &admissionv1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     data,
+		PatchType: &pt,
+	}
+}
+
+func (s *Server) validate(pod *corev1.Pod) *admissionv1.AdmissionResponse {
+	if err := Validate(pod); err != nil {
+		glog.Infof("rejecting pod %s/%s: %v", pod.Namespace, podName(pod), err)
+		return errorResponse(http.StatusForbidden, err)
+	}
+	return &admissionv1.AdmissionResponse{Allowed: true}
+}
+
+// serve decodes the pod of the admission review, and responds with the review's response
+func serve(admit func(pod *corev1.Pod) *admissionv1.AdmissionResponse) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		review := &admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+			http.Error(w, fmt.Sprintf("failed to decode admission review: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		var resp *admissionv1.AdmissionResponse
+		pod := &corev1.Pod{}
+		if err := json.Unmarshal(review.Request.Object.Raw, pod); err != nil {
+			resp = errorResponse(http.StatusBadRequest, fmt.Errorf("failed to decode pod: %w", err))
+		} else {
+			if pod.Namespace == "" {
+				pod.Namespace = review.Request.Namespace
+			}
+			resp = admit(pod)
+		}
+		resp.UID = review.Request.UID
+		review.Response = resp
+		review.Request = nil
+
+		data, err := json.Marshal(review)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(data); err != nil {
+			glog.Errorf("failed to write admission response: %v", err)
+		}
+	}
+}
+
+func errorResponse(code int32, err error) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    code,
+			Message: err.Error(),
+		},
+	}
+}
+
+// podName returns the pod's name, or its generate name, since pods are named after admission
+func podName(pod *corev1.Pod) string {
+	if pod.Name != "" {
+		return pod.Name
+	}
+	return pod.GenerateName
+}
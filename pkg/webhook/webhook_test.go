@@ -0,0 +1,110 @@
+/*
+ * Copyright 2023 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// review posts an admission review of the pod to the webhook at path
+func review(t *testing.T, srv *httptest.Server, path string, pod *corev1.Pod) *admissionv1.AdmissionResponse {
+	t.Helper()
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(&admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID("1234"),
+			Namespace: "ns",
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(srv.URL+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code %d", resp.StatusCode)
+	}
+	out := &admissionv1.AdmissionReview{}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Response == nil || out.Response.UID != "1234" {
+		t.Fatalf("unexpected admission response %+v", out.Response)
+	}
+	return out.Response
+}
+
+func TestServer(t *testing.T) {
+	srv := httptest.NewServer((&Server{Inject: true}).Handler())
+	defer srv.Close()
+
+	pod := makePod(makeContainer("app", map[corev1.ResourceName]string{"cpu": "2", "memory": "100M"}, nil))
+	pod.Annotations = map[string]string{InjectAnnotation: "app"}
+	resp := review(t, srv, MutatePath, pod)
+	if !resp.Allowed || resp.PatchType == nil || *resp.PatchType != admissionv1.PatchTypeJSONPatch {
+		t.Fatalf("expected the pod to be patched, got: %+v", resp)
+	}
+	patch := []patchOperation{}
+	if err := json.Unmarshal(resp.Patch, &patch); err != nil {
+		t.Fatal(err)
+	}
+	mutated := applyPatch(t, pod, patch)
+	if q := mutated.Spec.Containers[0].Resources.Limits[mutualCPU]; q.String() != "1" {
+		t.Fatalf("expected the mutual cpus device to be injected, got: %+v", mutated.Spec.Containers[0].Resources.Limits)
+	}
+
+	// the mutated pod is admitted
+	if resp := review(t, srv, ValidatePath, mutated); !resp.Allowed {
+		t.Fatalf("expected the pod to be allowed, got: %+v", resp.Result)
+	}
+
+	// a burstable pod is rejected
+	mutated.Spec.Containers[0].Resources.Limits = corev1.ResourceList{mutualCPU: mutated.Spec.Containers[0].Resources.Limits[mutualCPU]}
+	resp = review(t, srv, ValidatePath, mutated)
+	if resp.Allowed || resp.Result == nil || resp.Result.Code != http.StatusForbidden {
+		t.Fatalf("expected the pod to be rejected, got: %+v", resp)
+	}
+}
+
+func TestServerBadRequest(t *testing.T) {
+	srv := httptest.NewServer((&Server{}).Handler())
+	defer srv.Close()
+	resp, err := http.Post(srv.URL+ValidatePath, "application/json", bytes.NewReader([]byte("{")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code %d", resp.StatusCode)
+	}
+}
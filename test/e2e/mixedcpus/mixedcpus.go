@@ -59,13 +59,13 @@ var _ = Describe("Mixedcpus", func() {
 		It("should generate more devices", func() {
 			By("create deployment which asks more devices than the node has")
 			pod := pods.Make("pod-test", fxt.NS.Name, pods.WithLimits(corev1.ResourceList{
-				deviceplugin.MutualCPUDeviceName: resource.MustParse("1"),
+				corev1.ResourceName(deviceplugin.MutualCPUDeviceName): resource.MustParse("1"),
 			}))
 			workers, err := nodes.GetWorkers(fxt.Ctx, fxt.Cli)
 			Expect(err).ToNot(HaveOccurred())
 			var devicesCap resource.Quantity
 			for _, worker := range workers {
-				devicesPerWorker := worker.Status.Capacity.Name(deviceplugin.MutualCPUDeviceName, resource.DecimalSI)
+				devicesPerWorker := worker.Status.Capacity.Name(corev1.ResourceName(deviceplugin.MutualCPUDeviceName), resource.DecimalSI)
 				devicesCap.Add(*devicesPerWorker)
 			}
 			// we want to make sure we exhaust all devices in the cluster,
@@ -207,9 +207,9 @@ func checkMinimalCPUsForTesting(cli client.Client) {
 
 func createDeployment(cli client.Client, ns, name string) *appsv1.Deployment {
 	pod := pods.Make("pod-test", ns, pods.WithLimits(corev1.ResourceList{
-		corev1.ResourceCPU:               resource.MustParse("1"),
-		corev1.ResourceMemory:            resource.MustParse("100M"),
-		deviceplugin.MutualCPUDeviceName: resource.MustParse("1"),
+		corev1.ResourceCPU:    resource.MustParse("1"),
+		corev1.ResourceMemory: resource.MustParse("100M"),
+		corev1.ResourceName(deviceplugin.MutualCPUDeviceName): resource.MustParse("1"),
 	}))
 	dp := deployments.Make(name, ns, deployments.WithPodSpec(pod.Spec))
 	klog.Infof("create deployment %q with a pod requesting for shared cpus", client.ObjectKeyFromObject(dp).String())